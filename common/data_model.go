@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 type SqlRes = map[string]interface{}
 
 type TableTypesMap = map[string]string
@@ -13,9 +15,14 @@ type QuotaList struct {
     Name    string      `json:"name" form:"name"`
 }
 
+// DsData 指标值，同环比查询时给出对比周期的值、变化量以及变化率，对比值为空或0时变化率为空
+
 type DsData struct {
     Value interface{} `json:"value" form:"value"`
     Name  []string    `json:"name" form:"name"`
+    CompareValue interface{} `json:"compareValue,omitempty" form:"compareValue"`
+    Change  interface{} `json:"change,omitempty" form:"change"`
+    ChangeRate  interface{} `json:"changeRate,omitempty" form:"changeRate"`
 }
 
 type DsSeries struct {
@@ -23,10 +30,84 @@ type DsSeries struct {
     Data        []DsData            `json:"data" form:"data"`
 }
 
+// DsFieldValue 字段的一个取值以及出现次数，Label为展示文本(时间字段按标签格式输出)
+
+type DsFieldValue struct {
+    Value       interface{}     `json:"value" form:"value"`
+    Label       string          `json:"label" form:"label"`
+    Count       int64           `json:"count" form:"count"`
+}
+
+// FieldProfile 字段统计信息，用于选择维度/指标以及发现脏数据
+// DistinctApprox: 去重计数为近似值(如clickhouse的uniq)
+// Mean: 数值字段的均值，非数值字段为nil
+// TopValues: 出现次数最多的取值
+
+type FieldProfile struct {
+    FieldId         string              `json:"fieldId" form:"fieldId"`
+    Name            string              `json:"name" form:"name"`
+    RowCount        int64               `json:"rowCount" form:"rowCount"`
+    NullCount       int64               `json:"nullCount" form:"nullCount"`
+    DistinctCount   int64               `json:"distinctCount" form:"distinctCount"`
+    DistinctApprox  bool                `json:"distinctApprox" form:"distinctApprox"`
+    Min             interface{}         `json:"min" form:"min"`
+    Max             interface{}         `json:"max" form:"max"`
+    Mean            interface{}         `json:"mean" form:"mean"`
+    TopValues       []DsFieldValue      `json:"topValues" form:"topValues"`
+}
+
+// PivotHeader 透视表的行头/列头
+// Level为Values中的维度个数，等于维度数时为明细，小于时为前Level个维度的小计，0为总计
+
+type PivotHeader struct {
+    Values      []string    `json:"values" form:"values"`
+    Level       int         `json:"level" form:"level"`
+}
+
+// PivotResult 透视查询结果
+// Cells[i][j][k]为第i行第j列第k个指标的值，行列组合无数据时为nil
+
+type PivotResult struct {
+    RowFields       []string            `json:"rowFields" form:"rowFields"`
+    ColumnFields    []string            `json:"columnFields" form:"columnFields"`
+    Measures        []string            `json:"measures" form:"measures"`
+    Rows            []PivotHeader       `json:"rows" form:"rows"`
+    Columns         []PivotHeader       `json:"columns" form:"columns"`
+    Cells           [][][]interface{}   `json:"cells" form:"cells"`
+}
+
+// DsResult 查询结果
+// Total: 满足条件的总行数(聚合查询为分组数)，未统计时为-1
+// HasMore: 当前页之后是否还有数据
+// Offset/Limit: 实际生效的分页参数
+// Cursor: 下一页游标，数据集声明了唯一键且还有数据时给出
+// Pivot: 透视查询的结果
+// QueryId: 本次查询的ID，可用于CancelQuery
+
 type DsResult struct {
     X           []string                    `json:"x" form:"x"`
     Fields      []DatasetTableField         `json:"fields" form:"fields"`
     TableRow    []SqlRes                    `json:"tableRow" form:"tableRow"`
     Series      []DsSeries                  `json:"series" form:"series"`
+    Total       int64                       `json:"total" form:"total"`
+    HasMore     bool                        `json:"hasMore" form:"hasMore"`
+    Offset      int                         `json:"offset" form:"offset"`
+    Limit       int                         `json:"limit" form:"limit"`
+    Cursor      string                      `json:"cursor,omitempty" form:"cursor"`
+    Pivot       *PivotResult                `json:"pivot,omitempty" form:"pivot"`
+    QueryId     string                      `json:"queryId,omitempty" form:"queryId"`
+}
+
+// RunningQuery 执行中的查询
+// DatasetId: 按数据集定义直接查询(QueryDataByTable)时为空
+// Sql: 当前正在执行的语句，api数据源为请求地址，尚未下发时为空
+
+type RunningQuery struct {
+    QueryId         string              `json:"queryId" form:"queryId"`
+    DatasourceId    string              `json:"datasourceId" form:"datasourceId"`
+    DatasetId       string              `json:"datasetId" form:"datasetId"`
+    Sql             string              `json:"sql" form:"sql"`
+    StartTime       time.Time           `json:"startTime" form:"startTime"`
+    Caller          string              `json:"caller" form:"caller"`
 }
 
@@ -3,6 +3,8 @@ package common
 import (
     "database/sql/driver"
     "encoding/json"
+    "errors"
+    "fmt"
     "time"
 )
 
@@ -18,6 +20,13 @@ type Configuration struct {
     Username        string      `json:"username" form:"username"`
     Password        string      `json:"password" form:"password"`
     Port            string      `json:"port" form:"port"`
+    // 以下为http api数据源配置
+    BaseUrl         string      `json:"baseUrl,omitempty" form:"baseUrl"`          // 接口地址前缀，数据集Info为接口路径
+    AuthHeader      string      `json:"authHeader,omitempty" form:"authHeader"`    // 认证头，格式 "Name: value"，不带Name时作为Authorization
+    RowsPath        string      `json:"rowsPath,omitempty" form:"rowsPath"`        // 行数组在响应中的JSONPath，如 $.data.items
+    OffsetParam     string      `json:"offsetParam,omitempty" form:"offsetParam"`  // 分页offset参数名，默认offset
+    LimitParam      string      `json:"limitParam,omitempty" form:"limitParam"`    // 分页limit参数名，默认limit
+    MaxBodySize     uint        `json:"maxBodySize,omitempty" form:"maxBodySize"`  // 响应体最大字节数，默认64MB
 }
 
 func (c Configuration) Value() (driver.Value, error) {
@@ -29,7 +38,18 @@ func (c Configuration) Value() (driver.Value, error) {
 }
 
 func (c *Configuration) Scan(value interface{}) error {
-    if err := json.Unmarshal(value.([]byte), &c); err != nil {
+    var data []byte
+    // 部分驱动(如sqlite)以string返回text列
+    switch v := value.(type) {
+    case []byte:
+        data = v
+    case string:
+        data = []byte(v)
+    default:
+        return errors.New(fmt.Sprintf("configuration scan unsupported type [%T]", value))
+    }
+
+    if err := json.Unmarshal(data, &c); err != nil {
         return err
     }
     return nil
@@ -0,0 +1,59 @@
+package common
+
+// 过滤条件操作符
+const (
+    FilterOpEq = "eq"
+    FilterOpNe = "ne"
+    FilterOpGt = "gt"
+    FilterOpGe = "ge"
+    FilterOpLt = "lt"
+    FilterOpLe = "le"
+    FilterOpIn = "in"
+    FilterOpNotIn = "not_in"
+    FilterOpBetween = "between"
+    FilterOpLike = "like"
+    FilterOpIsNull = "is_null"
+    FilterOpNotNull = "not_null"
+)
+
+// 过滤条件分组逻辑
+const (
+    FilterLogicAnd = "and"
+    FilterLogicOr = "or"
+)
+
+// DsFilter 结构化过滤条件树
+// 分组节点：Logic + Children，Children之间按Logic组合
+// 条件节点：Field + Op + Values，Field为数据集字段名，Values个数由Op决定
+
+type DsFilter struct {
+    Logic       string          `json:"logic,omitempty" form:"logic"`
+    Children    []DsFilter      `json:"children,omitempty" form:"children"`
+    Field       string          `json:"field,omitempty" form:"field"`
+    Op          string          `json:"op,omitempty" form:"op"`
+    Values      []interface{}   `json:"values,omitempty" form:"values"`
+}
+
+// IsGroup 是否为分组节点
+
+func (f *DsFilter) IsGroup() bool {
+    return f.Logic != "" || len(f.Children) > 0
+}
+
+// IsEmpty 无任何条件
+
+func (f *DsFilter) IsEmpty() bool {
+    if f == nil {
+        return true
+    }
+    if !f.IsGroup() {
+        return f.Field == "" && f.Op == ""
+    }
+    for index, _ := range f.Children {
+        if !f.Children[index].IsEmpty() {
+            return false
+        }
+    }
+
+    return true
+}
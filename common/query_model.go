@@ -0,0 +1,137 @@
+package common
+
+// 指标聚合方式
+const (
+    AggSum = "sum"
+    AggAvg = "avg"
+    AggMin = "min"
+    AggMax = "max"
+    AggCount = "count"
+    AggCountDistinct = "count_distinct"
+)
+
+// 排序方向
+const (
+    SortAsc = "asc"
+    SortDesc = "desc"
+)
+
+// 空值排序位置，为空时由数据库决定
+const (
+    SortNullsFirst = "first"
+    SortNullsLast = "last"
+)
+
+// 时间字段分桶粒度，周以周一为起点
+const (
+    TimeGranMinute = "minute"
+    TimeGranHour = "hour"
+    TimeGranDay = "day"
+    TimeGranWeek = "week"
+    TimeGranMonth = "month"
+    TimeGranQuarter = "quarter"
+    TimeGranYear = "year"
+)
+
+// DsSort 排序键，Field为数据集字段名
+
+type DsSort struct {
+    Field       string      `json:"field" form:"field"`
+    Order       string      `json:"order,omitempty" form:"order"`     // asc/desc，默认asc
+    Nulls       string      `json:"nulls,omitempty" form:"nulls"`     // first/last
+}
+
+// DsTopN 按指标字段排名取前N个分组，其余分组合并为一个分组
+
+type DsTopN struct {
+    N           int         `json:"n" form:"n"`
+    Field       string      `json:"field" form:"field"`                       // 排名依据的指标字段名，需为选中的指标字段
+    Order       string      `json:"order,omitempty" form:"order"`             // desc(默认)取最大的N组，asc取最小的N组
+    OthersName  string      `json:"othersName,omitempty" form:"othersName"` // 合并分组的维度值，默认Others
+}
+
+const TopNOthersName = "Others"
+
+// DsPivot 透视查询参数，行、列维度以及指标均为数据集字段名
+// 指标为空时取数据集中所有选中的指标字段
+
+type DsPivot struct {
+    Rows        []string    `json:"rows,omitempty" form:"rows"`
+    Columns     []string    `json:"columns,omitempty" form:"columns"`
+    Measures    []string    `json:"measures,omitempty" form:"measures"`
+    Subtotal    bool        `json:"subtotal,omitempty" form:"subtotal"`     // 是否按维度层级输出小计，总计始终输出
+}
+
+// DsCompare 同环比参数，时间维度需按粒度分桶
+// Period为对比周期，取值同分桶粒度，如按天分桶、Period为week即与上周同一天对比
+
+type DsCompare struct {
+    Field       string      `json:"field" form:"field"`                   // 时间维度字段名
+    Period      string      `json:"period" form:"period"`                 // 对比周期：minute/hour/day/week/month/quarter/year
+    Periods     int         `json:"periods,omitempty" form:"periods"`     // 向前偏移的周期数，默认1
+}
+
+// DsQuery 数据查询参数
+// Sorts: 排序键列表，按顺序组装成order by的参数
+// Filter: 结构化过滤条件，字段需在数据集中定义，条件值以绑定参数下发
+// Aggregate: 服务端聚合，按维度字段(d)分组，指标字段(q)按各自AggFunc聚合
+// SkipCount: 不统计总行数，分页时可省去一次count查询
+// Cursor: 上一页结果返回的游标，给出时从游标之后继续取Limit条，Offset需为0
+// Variables: sql类型数据集的变量取值，未给出的变量使用默认值
+// Granularity: 聚合查询中时间维度的分桶粒度，key为字段名，覆盖字段上配置的TimeGranularity
+// TopN: 排名查询，按维度分组后取前N组并追加合并其余分组的Others，隐含Aggregate，不支持分页
+// Pivot: 透视查询，结果在DsResult.Pivot中给出，隐含Aggregate，不支持分页
+// Compare: 同环比，各指标在DsData中给出对比周期的值以及变化量、变化率，隐含Aggregate
+// QueryId: 查询ID，为空时自动生成，调用方预先给出时可在查询返回前用于CancelQuery
+// Caller: 调用方标识(如用户、看板)，仅用于ListRunningQueries展示
+
+type DsQuery struct {
+    Offset      int             `json:"offset" form:"offset"`
+    Limit       int             `json:"limit" form:"limit"`
+    Sorts       []DsSort        `json:"sorts,omitempty" form:"sorts"`
+    Filter      *DsFilter       `json:"filter,omitempty" form:"filter"`
+    Aggregate   bool            `json:"aggregate,omitempty" form:"aggregate"`
+    SkipCount   bool            `json:"skipCount,omitempty" form:"skipCount"`
+    Cursor      string          `json:"cursor,omitempty" form:"cursor"`
+    Variables   map[string]interface{} `json:"variables,omitempty" form:"variables"`
+    Granularity map[string]string `json:"granularity,omitempty" form:"granularity"`
+    TopN        *DsTopN         `json:"topN,omitempty" form:"topN"`
+    Pivot       *DsPivot        `json:"pivot,omitempty" form:"pivot"`
+    Compare     *DsCompare      `json:"compare,omitempty" form:"compare"`
+    QueryId     string          `json:"queryId,omitempty" form:"queryId"`
+    Caller      string          `json:"caller,omitempty" form:"caller"`
+}
+
+// NeedAggregation 查询是否需要数据源支持服务端聚合
+
+func (q *DsQuery) NeedAggregation() bool {
+    return q.Aggregate || q.TopN != nil || q.Pivot != nil || q.Compare != nil
+}
+
+// 字段取值的搜索方式
+const (
+    ValueMatchPrefix = "prefix"
+    ValueMatchContains = "contains"
+)
+
+// DsValueOptions 字段取值查询的可选参数
+// Match: 搜索方式，prefix(默认)按前缀匹配，contains按包含匹配
+// Filter: 其他字段的过滤条件，用于级联筛选
+// Variables: sql类型数据集的变量取值
+
+type DsValueOptions struct {
+    Match       string          `json:"match,omitempty" form:"match"`
+    Filter      *DsFilter       `json:"filter,omitempty" form:"filter"`
+    Variables   map[string]interface{} `json:"variables,omitempty" form:"variables"`
+}
+
+// SqlVariable sql类型数据集的变量定义，以json数组保存在DatasetTable.SqlVariableDetails
+// Info中以${name}引用，查询时替换为绑定参数；DsType取值同字段DsType
+
+type SqlVariable struct {
+    Name        string          `json:"name" form:"name"`
+    DsType      int64           `json:"dsType" form:"dsType"`
+    Default     interface{}     `json:"default,omitempty" form:"default"`
+    Required    bool            `json:"required,omitempty" form:"required"`
+    Allowed     []interface{}   `json:"allowed,omitempty" form:"allowed"`     // 允许的取值，为空时不限制
+}
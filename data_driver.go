@@ -1,6 +1,7 @@
 package data_driver
 
 import (
+    "context"
     "errors"
     "fmt"
     "github.com/bingLAN/data_driver/common"
@@ -13,37 +14,121 @@ import (
 type DataDriver struct {
     datasources     *datasource.Datasources
     datasets        *dataset.Datasets
+    queries         *db_driver.QueryRegistry
+}
+
+// 根据查询参数生成执行中查询的登记信息
+
+func runningQueryInfo(datasourceId string, datasetId string, query *common.DsQuery) *common.RunningQuery {
+    info := &common.RunningQuery{DatasourceId: datasourceId, DatasetId: datasetId}
+    if query != nil {
+        info.QueryId = query.QueryId
+        info.Caller = query.Caller
+    }
+
+    return info
 }
 
 // 根据datasetId找到对应的数据对象，然后调用对应的接口来获取数据
-// sortNames: 排序字段，最终会组装成order by的参数
-// sortOpt: 排序方式，asc/desc
+// ctx: 取消时(如http请求断开)中止数据库查询，查询同时受数据源QueryTimeout限制
+// query: 分页、排序、过滤以及聚合参数，为nil时查询全部原始数据
+// 查询执行期间登记在ListRunningQueries中，可通过CancelQuery取消，结果中给出QueryId
 
-func (d *DataDriver) GetData(datasetId string, db *gorm.DB, offset, limit int, sortNames []string, sortOpt string, filter string) (*common.DsResult, error) {
+func (d *DataDriver) GetData(ctx context.Context, datasetId string, db *gorm.DB, query *common.DsQuery) (*common.DsResult, error) {
     ds, err := d.datasets.GetDatasetById(datasetId)
     if err != nil {
         return nil, err
     }
-    
-    return ds.GetData(datasetId, db, offset, limit, sortNames, sortOpt, filter)
+
+    info := runningQueryInfo(ds.DatasetInfo.DatasourceId, datasetId, query)
+    ctx, done, err := d.queries.Start(ctx, info)
+    if err != nil {
+        return nil, err
+    }
+    defer done()
+
+    res, err := ds.GetData(ctx, datasetId, db, query)
+    if err != nil {
+        return nil, err
+    }
+    res.QueryId = info.QueryId
+
+    return res, nil
+}
+
+// 查询字段的去重取值以及出现次数，用于筛选下拉框
+// search: 为空时不搜索，否则按options.Match在文本字段上前缀(默认)或包含匹配
+// limit: 返回的取值个数，<=0时取默认值100
+// options: 可为nil，可给出其他字段的过滤条件以及sql类型数据集的变量取值
+
+func (d *DataDriver) GetFieldValues(ctx context.Context, datasetId string, db *gorm.DB, fieldName string, search string, limit int,
+    options *common.DsValueOptions) ([]common.DsFieldValue, error) {
+    ds, err := d.datasets.GetDatasetById(datasetId)
+    if err != nil {
+        return nil, err
+    }
+
+    return ds.GetFieldValues(ctx, db, fieldName, search, limit, options)
+}
+
+// 统计数据集各字段的空值数、去重数、最值、均值以及高频取值，key为FieldId
+// sql类型数据集的变量使用默认值
+
+func (d *DataDriver) ProfileDataset(ctx context.Context, datasetId string, db *gorm.DB) (map[string]common.FieldProfile, error) {
+    ds, err := d.datasets.GetDatasetById(datasetId)
+    if err != nil {
+        return nil, err
+    }
+
+    return ds.ProfileFields(ctx, db)
 }
 
 // 该接口用于数据集填写还未下发时查询数据集数据样本
 
-func (d *DataDriver) QueryDataByTable(dsTable common.DatasetTable, offset, limit int, sortNames []string, sortOpt string) (*common.DsResult, error) {
+func (d *DataDriver) QueryDataByTable(ctx context.Context, dsTable common.DatasetTable, query *common.DsQuery) (*common.DsResult, error) {
     datasourceId := dsTable.DatasourceId
     datasource, err := d.datasources.GetDatasourceFromCache(datasourceId)
     if err != nil {
         return nil, errors.New(fmt.Sprintf("datasourceId [%s] exist", datasourceId))
     }
 
+    info := runningQueryInfo(datasourceId, "", query)
+    ctx, done, err := d.queries.Start(ctx, info)
+    if err != nil {
+        return nil, err
+    }
+    defer done()
+
     // 调用驱动层获取fields, fieldId已在驱动层填充
-    fields, err := datasource.DBDriver.GetDataFields(dsTable)
+    fields, err := datasource.DBDriver.GetDataFields(ctx, dsTable)
     if err != nil {
         return nil, err
     }
 
-    return datasource.DBDriver.GetData("", &dsTable, fields, offset, limit, sortNames, sortOpt, "")
+    if query != nil && query.NeedAggregation() && !datasource.Capability().Aggregation {
+        return nil, errors.New(fmt.Sprintf("datasource [%s] not support aggregate", datasourceId))
+    }
+
+    res, err := datasource.DBDriver.GetData(ctx, "", &dsTable, fields, query)
+    if err != nil {
+        return nil, err
+    }
+    res.QueryId = info.QueryId
+
+    return res, nil
+}
+
+// 查看执行中的数据查询(GetData/QueryDataByTable)，按开始时间排序
+
+func (d *DataDriver) ListRunningQueries() []common.RunningQuery {
+    return d.queries.List()
+}
+
+// 取消执行中的查询，查询随即以context.Canceled返回
+// clickhouse数据源同时下发kill query终止服务端执行，kill失败时返回错误，但查询已被取消
+
+func (d *DataDriver) CancelQuery(queryId string) error {
+    return d.queries.Cancel(queryId)
 }
 
 
@@ -102,6 +187,17 @@ func (d *DataDriver) CheckDatasource(dt common.DatasourceTable, db *gorm.DB) (db
     return status, err
 }
 
+// 查看数据源能力，用于构建查询前判断是否支持采样、窗口函数、服务端聚合等特性
+
+func (d *DataDriver) GetDatasourceCapability(datasourceId string) (db_driver.DBCapability, error) {
+    datasource, err := d.datasources.GetDatasourceFromCache(datasourceId)
+    if err != nil {
+        return db_driver.DBCapability{}, err
+    }
+
+    return datasource.Capability(), nil
+}
+
 // 扫描所有数据集
 
 func (d *DataDriver) ScanDatasets(db *gorm.DB) ([]common.DatasetTable, error) {
@@ -153,6 +249,20 @@ func (d *DataDriver) ModifyDatasetFields(fields []common.DatasetTableField, db *
     return d.datasets.ModifyDatasetFields(fields, db)
 }
 
+// 添加计算字段
+// field.DatasetId: 所属数据集；field.Name: 字段名；field.OriginName: 表达式，如 ul_bytes + dl_bytes、if(x > 0, y / x, 0)
+// field.GroupType为空时数值结果作为指标，其他作为维度
+
+func (d *DataDriver) AddCalcField(field *common.DatasetTableField, db *gorm.DB) error {
+    return d.datasets.AddCalcField(field, db)
+}
+
+// 删除计算字段
+
+func (d *DataDriver) DelCalcField(datasetId string, fieldId string, db *gorm.DB) error {
+    return d.datasets.DelCalcField(datasetId, fieldId, db)
+}
+
 
 // driver初始化，自动从数据库中加载数据源和数据集
 
@@ -169,5 +279,5 @@ func CreateDataDriver(db *gorm.DB) (*DataDriver, error) {
         return nil, err
     }
 
-    return &DataDriver{datasources: datasources, datasets: datasets}, nil
+    return &DataDriver{datasources: datasources, datasets: datasets, queries: db_driver.NewQueryRegistry()}, nil
 }
\ No newline at end of file
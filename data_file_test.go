@@ -0,0 +1,252 @@
+package data_driver
+
+import (
+    "context"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "github.com/bingLAN/data_driver/db_driver"
+    "github.com/xuri/excelize/v2"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func fileDataInit(t *testing.T) string {
+    dir := t.TempDir()
+
+    csvData := "province,city,bytes,rate,day\n" +
+        "北京市,北京,100,0.5,2023-05-01\n" +
+        "广东省,广州,500,2.5,2023-05-02\n" +
+        "广东省,深圳,700,,2023-05-03\n" +
+        "上海市,上海,900,4.5,2023-05-03\n"
+    err := os.WriteFile(filepath.Join(dir, "flow.csv"), []byte(csvData), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    xlsx := excelize.NewFile()
+    sheet := xlsx.GetSheetName(0)
+    rows := [][]interface{}{
+        {"name", "count"},
+        {"a", 3},
+        {"b", 1},
+        {"c", 2},
+    }
+    for index, row := range rows {
+        cell, _ := excelize.CoordinatesToCellName(1, index + 1)
+        if err = xlsx.SetSheetRow(sheet, cell, &row); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err = xlsx.SaveAs(filepath.Join(dir, "count.xlsx")); err != nil {
+        t.Fatal(err)
+    }
+
+    return dir
+}
+
+func TestFileData(t *testing.T) {
+    db := gormSqliteInit(t)
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+
+    datasource := common.DatasourceTable{
+        Name: "file_test",
+        Type: "file",
+        Config: common.Configuration{
+            DataBase: fileDataInit(t),
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // csv数据集
+    dataset := common.DatasetTable{
+        Name: "flow_csv",
+        DatasourceId: datasource.DatasourceId,
+        Type: "excel",
+        Info: "flow.csv",
+    }
+    err = dd.AddDataset(&dataset, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    fields, err := dd.ScanDatasetFields(dataset.DatasetId, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantTypes := map[string]int64{
+        "province": common.DSTypeVar,
+        "city": common.DSTypeVar,
+        "bytes": common.DSTypeInt,
+        "rate": common.DSTypeDEC,
+        "day": common.DSTypeTime,
+    }
+    if len(fields) != len(wantTypes) {
+        t.Fatalf("fields len %d, want %d", len(fields), len(wantTypes))
+    }
+    for _, field := range fields {
+        if field.DsType != wantTypes[field.Name] {
+            t.Errorf("field [%s] DsType %d, want %d", field.Name, field.DsType, wantTypes[field.Name])
+        }
+    }
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+        Offset: 1,
+        Limit: 2,
+        Sorts: []common.DsSort{{Field: "bytes", Order: "desc"}},
+        Filter: &common.DsFilter{
+            Field: "province",
+            Op: common.FilterOpNe,
+            Values: []interface{}{"上海市"},
+        },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 2 {
+        t.Fatalf("rows len %d, want 2", len(res.TableRow))
+    }
+    if res.TableRow[0]["city"] != "广州" || res.TableRow[1]["city"] != "北京" {
+        t.Errorf("rows %v, want 广州, 北京", res.TableRow)
+    }
+
+    // xlsx数据集
+    xlsxDataset := common.DatasetTable{
+        Name: "count_xlsx",
+        DatasourceId: datasource.DatasourceId,
+        Type: "excel",
+        Info: "count.xlsx",
+    }
+    err = dd.AddDataset(&xlsxDataset, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    res, err = dd.GetData(context.Background(), xlsxDataset.DatasetId, db, &common.DsQuery{Sorts: []common.DsSort{{Field: "count", Order: "asc"}}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, row := range res.TableRow {
+        names = append(names, fmt.Sprintf("%v", row["name"]))
+    }
+    if fmt.Sprintf("%v", names) != "[b c a]" {
+        t.Errorf("names %v, want [b c a]", names)
+    }
+
+    // 不允许访问数据源目录之外的文件
+    err = dd.AddDataset(&common.DatasetTable{
+        Name: "outside",
+        DatasourceId: datasource.DatasourceId,
+        Type: "excel",
+        Info: "../flow.csv",
+    }, db)
+    if err == nil {
+        t.Errorf("dataset outside of datasource directory should fail")
+    }
+    for _, info := range []string{"../../etc/passwd", "sub/../../flow.csv", filepath.Join(filepath.Dir(datasource.Config.DataBase), "flow.csv")} {
+        _, err = dd.QueryDataByTable(context.Background(), common.DatasetTable{
+            DatasourceId: datasource.DatasourceId,
+            Type: "excel",
+            Info: info,
+        }, nil)
+        if err == nil {
+            t.Errorf("file [%s] outside of datasource directory should fail", info)
+        }
+    }
+
+    // 未给出根目录的数据源不可用
+    noRoot := common.DatasourceTable{
+        Name: "file_no_root",
+        Type: "file",
+    }
+    err = dd.AddDatasource(&noRoot, db)
+    if err == nil {
+        t.Errorf("file datasource without directory should fail")
+    }
+    status, _ := dd.CheckDatasource(noRoot, db)
+    if status != db_driver.ConnFail {
+        t.Errorf("file datasource without directory status %d, want ConnFail", status)
+    }
+}
+
+// 两位年份的文本不识别为时间，xlsx中的日期单元格按原始值识别
+
+func TestFileTimeInfer(t *testing.T) {
+    db := gormSqliteInit(t)
+    dir := t.TempDir()
+
+    err := os.WriteFile(filepath.Join(dir, "codes.csv"), []byte("code,day\n12-05-24,2023-05-01\n01-02-23,2023-05-02\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    xlsx := excelize.NewFile()
+    sheet := xlsx.GetSheetName(0)
+    rows := [][]interface{}{
+        {"code", "day"},
+        {"12-05-24", time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC)},
+        {"01-02-23", time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)},
+    }
+    for index, row := range rows {
+        cell, _ := excelize.CoordinatesToCellName(1, index + 1)
+        if err = xlsx.SetSheetRow(sheet, cell, &row); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err = xlsx.SaveAs(filepath.Join(dir, "codes.xlsx")); err != nil {
+        t.Fatal(err)
+    }
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+
+    datasource := common.DatasourceTable{
+        Name: "file_time",
+        Type: "file",
+        Config: common.Configuration{
+            DataBase: dir,
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, info := range []string{"codes.csv", "codes.xlsx"} {
+        res, err := dd.QueryDataByTable(context.Background(), common.DatasetTable{
+            DatasourceId: datasource.DatasourceId,
+            Type: "excel",
+            Info: info,
+        }, &common.DsQuery{Sorts: []common.DsSort{{Field: "day"}}})
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, field := range res.Fields {
+            want := common.DSTypeVar
+            if field.Name == "day" {
+                want = common.DSTypeTime
+            }
+            if field.DsType != want {
+                t.Errorf("%s field [%s] DsType %d, want %d", info, field.Name, field.DsType, want)
+            }
+        }
+        if len(res.TableRow) != 2 || res.TableRow[0]["code"] != "12-05-24" {
+            t.Fatalf("%s rows %v, want code 12-05-24 first", info, res.TableRow)
+        }
+        if day := fmt.Sprintf("%v", res.TableRow[1]["day"]); len(day) < 10 || day[:10] != "2023-05-02" {
+            t.Errorf("%s day [%s], want 2023-05-02", info, day)
+        }
+    }
+}
@@ -0,0 +1,312 @@
+package data_driver
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "github.com/bingLAN/data_driver/common"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+type flowRecord struct {
+    Province    string      `json:"province"`
+    Bytes       int64       `json:"bytes"`
+    Rate        float64     `json:"rate"`
+    Day         string      `json:"day"`
+}
+
+// 模拟接口：{"code":0,"data":{"items":[...]}}，支持offset/limit/sort/order参数
+
+func flowApiServer(t *testing.T) *httptest.Server {
+    records := []flowRecord{
+        {"北京市", 100, 0.5, "2023-05-01"},
+        {"广东省", 500, 2, "2023-05-02"},
+        {"上海市", 900, 4.5, "2023-05-03"},
+        {"浙江省", 300, 1.5, "2023-05-04"},
+    }
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("X-Token") != "secret" {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        if r.URL.Path != "/flow" {
+            w.WriteHeader(http.StatusNotFound)
+            return
+        }
+
+        items := append([]flowRecord(nil), records...)
+        query := r.URL.Query()
+        if query.Get("sort") == "bytes" {
+            sort.Slice(items, func(i, j int) bool {
+                if query.Get("order") == "desc" {
+                    return items[i].Bytes > items[j].Bytes
+                }
+                return items[i].Bytes < items[j].Bytes
+            })
+        }
+        offset, _ := strconv.Atoi(query.Get("offset"))
+        limit, _ := strconv.Atoi(query.Get("limit"))
+        if offset > len(items) {
+            offset = len(items)
+        }
+        items = items[offset:]
+        if limit > 0 && limit < len(items) {
+            items = items[:limit]
+        }
+
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "code": 0,
+            "data": map[string]interface{}{"items": items},
+        })
+    }))
+    t.Cleanup(server.Close)
+
+    return server
+}
+
+func TestHttpData(t *testing.T) {
+    db := gormSqliteInit(t)
+    server := flowApiServer(t)
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+
+    datasource := common.DatasourceTable{
+        Name: "http_test",
+        Type: "http",
+        Config: common.Configuration{
+            ConnectTimeout: 5,
+            QueryTimeout: 5,
+            BaseUrl: server.URL,
+            AuthHeader: "X-Token: secret",
+            RowsPath: "$.data.items",
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    dataset := common.DatasetTable{
+        Name: "flow_api",
+        DatasourceId: datasource.DatasourceId,
+        Type: "api",
+        Info: "/flow",
+    }
+    err = dd.AddDataset(&dataset, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    fields, err := dd.ScanDatasetFields(dataset.DatasetId, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantFields := []struct {
+        name    string
+        dsType  int64
+    }{
+        {"province", common.DSTypeVar},
+        {"bytes", common.DSTypeInt},
+        {"rate", common.DSTypeDEC},
+        {"day", common.DSTypeTime},
+    }
+    if len(fields) != len(wantFields) {
+        t.Fatalf("fields len %d, want %d", len(fields), len(wantFields))
+    }
+    for index, want := range wantFields {
+        if fields[index].Name != want.name || fields[index].DsType != want.dsType {
+            t.Errorf("field %d [%s:%d], want [%s:%d]", index, fields[index].Name, fields[index].DsType, want.name, want.dsType)
+        }
+    }
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Offset: 1, Limit: 2, Sorts: []common.DsSort{{Field: "bytes", Order: "desc"}}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 2 {
+        t.Fatalf("rows len %d, want 2", len(res.TableRow))
+    }
+    if !res.HasMore || res.Total != -1 {
+        t.Errorf("hasMore %v total %d, want true -1", res.HasMore, res.Total)
+    }
+    if res.TableRow[0]["province"] != "广东省" || res.TableRow[0]["bytes"] != int64(500) {
+        t.Errorf("first row %v, want 广东省 500", res.TableRow[0])
+    }
+
+    _, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Sorts: []common.DsSort{{Field: "unknown", Order: "asc"}}})
+    if err == nil {
+        t.Errorf("sort by undefined field should fail")
+    }
+
+    // 字段改名后按展示名排序、输出，下发给接口的仍是原始字段名
+    for index, _ := range fields {
+        if fields[index].Name == "bytes" {
+            fields[index].Name = "流量"
+        }
+    }
+    err = dd.ModifyDatasetFields(fields, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 1, Sorts: []common.DsSort{{Field: "流量", Order: "desc"}}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 1 || res.TableRow[0]["流量"] != int64(900) {
+        t.Errorf("rows %v, want 流量 900", res.TableRow)
+    }
+}
+
+// 执行中查询登记的请求地址不包含ExtraParams中的token
+
+func TestHttpRunningQuery(t *testing.T) {
+    db := gormSqliteInit(t)
+
+    release := make(chan struct{})
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            select {
+            case <-release:
+            case <-r.Context().Done():
+            }
+        }
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": []map[string]interface{}{{"a": 1}}})
+    }))
+    t.Cleanup(server.Close)
+    t.Cleanup(func() { close(release) })
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+
+    datasource := common.DatasourceTable{
+        Name: "http_running",
+        Type: "http",
+        Config: common.Configuration{
+            ConnectTimeout: 5,
+            QueryTimeout: 5,
+            BaseUrl: server.URL,
+            ExtraParams: "api_key=topsecret",
+            RowsPath: "$.items",
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    errCh := make(chan error, 1)
+    go func() {
+        _, err := dd.QueryDataByTable(context.Background(), common.DatasetTable{
+            DatasourceId: datasource.DatasourceId,
+            Type: "api",
+            Info: "/slow",
+        }, &common.DsQuery{QueryId: "slow_api"})
+        errCh <- err
+    }()
+
+    var running []common.RunningQuery
+    for i := 0; i < 100 && (len(running) == 0 || running[0].Sql == ""); i++ {
+        time.Sleep(10 * time.Millisecond)
+        running = dd.ListRunningQueries()
+    }
+    if len(running) != 1 || !strings.Contains(running[0].Sql, "/slow") {
+        t.Fatalf("running queries %+v, want slow api", running)
+    }
+    if strings.Contains(running[0].Sql, "topsecret") || strings.Contains(running[0].Sql, "api_key") {
+        t.Errorf("running query url [%s] exposes extra params", running[0].Sql)
+    }
+
+    err = dd.CancelQuery("slow_api")
+    if err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case err = <-errCh:
+        if !errors.Is(err, context.Canceled) {
+            t.Errorf("canceled api query err [%v], want context canceled", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("api query not canceled")
+    }
+}
+
+// 只给offset时不下发limit，响应体超过MaxBodySize时报错
+
+func TestHttpLimit(t *testing.T) {
+    db := gormSqliteInit(t)
+
+    var lastQuery url.Values
+    var lock sync.Mutex
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        lock.Lock()
+        lastQuery = r.URL.Query()
+        lock.Unlock()
+        items := []map[string]interface{}{{"a": 1}}
+        if r.URL.Path == "/big" {
+            items = append(items, map[string]interface{}{"a": strings.Repeat("x", 4096)})
+        }
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+    }))
+    t.Cleanup(server.Close)
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+
+    datasource := common.DatasourceTable{
+        Name: "http_limit",
+        Type: "http",
+        Config: common.Configuration{
+            ConnectTimeout: 5,
+            QueryTimeout: 5,
+            BaseUrl: server.URL,
+            RowsPath: "$.items",
+            MaxBodySize: 1024,
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, err = dd.QueryDataByTable(context.Background(), common.DatasetTable{
+        DatasourceId: datasource.DatasourceId,
+        Type: "api",
+        Info: "/small",
+    }, &common.DsQuery{Offset: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    lock.Lock()
+    if _, ok := lastQuery["limit"]; ok || lastQuery.Get("offset") != "1" {
+        t.Errorf("request query [%s], want offset without limit", lastQuery.Encode())
+    }
+    lock.Unlock()
+
+    _, err = dd.QueryDataByTable(context.Background(), common.DatasetTable{
+        DatasourceId: datasource.DatasourceId,
+        Type: "api",
+        Info: "/big",
+    }, &common.DsQuery{})
+    if err == nil || !strings.Contains(err.Error(), "exceeds") {
+        t.Errorf("big response err [%v], want body size error", err)
+    }
+}
@@ -0,0 +1,1487 @@
+package data_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "path/filepath"
+    "github.com/bingLAN/data_driver/common"
+    "github.com/bingLAN/data_driver/db_driver"
+    "gorm.io/driver/sqlite"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+    "strings"
+    "testing"
+    "time"
+)
+
+// 使用sqlite作为元数据库以及数据源，无需外部服务即可跑通DataDriver流程
+
+func gormSqliteInit(t *testing.T) *gorm.DB {
+    db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "meta.db")), &gorm.Config{
+        Logger: logger.Default.LogMode(logger.Silent),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = db.AutoMigrate(&common.DatasourceTable{}, &common.DatasetTable{}, &common.DatasetTableField{})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return db
+}
+
+func sqliteDataInit(t *testing.T) string {
+    path := filepath.Join(t.TempDir(), "data.db")
+    db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+        Logger: logger.Default.LogMode(logger.Silent),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    stmts := []string{
+        "create table flow (province varchar(32), city text, ul_bytes integer, dl_bytes bigint, rate real, ts datetime)",
+        "insert into flow values ('北京市', '北京', 100, 200, 0.5, '2023-05-01 10:00:00')",
+        "insert into flow values ('北京市', '北京', 300, 400, 1.5, '2023-05-01 11:00:00')",
+        "insert into flow values ('广东省', '广州', 500, 600, 2.5, '2023-05-02 10:00:00')",
+        "insert into flow values ('广东省', '深圳', 700, 800, 3.5, '2023-05-03 10:00:00')",
+        "insert into flow values ('上海市', '上海', 900, 1000, 4.5, '2023-05-03 12:00:00')",
+        // 表名、字段名为保留字或包含空格
+        "create table \"user order\" (\"group\" text, \"select count\" integer)",
+        "insert into \"user order\" values ('b', 1)",
+        "insert into \"user order\" values ('a', 2)",
+    }
+    for _, stmt := range stmts {
+        if err = db.Exec(stmt).Error; err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    sqlDB, _ := db.DB()
+    _ = sqlDB.Close()
+
+    return path
+}
+
+func sqliteDriverInit(t *testing.T) (*DataDriver, *gorm.DB, *common.DatasourceTable) {
+    db := gormSqliteInit(t)
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(dd.Close)
+
+    datasource := common.DatasourceTable{
+        Name: "sqlite_test",
+        Type: "sqlite",
+        Config: common.Configuration{
+            MaxPoolSize: 5,
+            MaxIdleTime: 5,
+            ConnectTimeout: 5,
+            QueryTimeout: 30,
+            DataBase: sqliteDataInit(t),
+        },
+    }
+
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return dd, db, &datasource
+}
+
+// 创建数据集并扫描字段
+
+func sqliteDatasetAdd(t *testing.T, dd *DataDriver, db *gorm.DB, dataset *common.DatasetTable) []common.DatasetTableField {
+    err := dd.AddDataset(dataset, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    fields, err := dd.ScanDatasetFields(dataset.DatasetId, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return fields
+}
+
+// 在flow表上创建db类型数据集
+
+func sqliteDatasetInit(t *testing.T, name string) (*DataDriver, *gorm.DB, *common.DatasetTable, []common.DatasetTableField) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    dataset := common.DatasetTable{
+        Name: name,
+        DatasourceId: datasource.DatasourceId,
+        Type: "db",
+        Info: "flow",
+    }
+    fields := sqliteDatasetAdd(t, dd, db, &dataset)
+
+    return dd, db, &dataset, fields
+}
+
+// 逐个修改字段后保存，返回修改后的字段
+
+func sqliteFieldsModify(t *testing.T, dd *DataDriver, db *gorm.DB, fields []common.DatasetTableField,
+    modify func(field *common.DatasetTableField)) []common.DatasetTableField {
+    modified := make([]common.DatasetTableField, len(fields))
+    copy(modified, fields)
+    for index, _ := range modified {
+        modify(&modified[index])
+    }
+
+    err := dd.ModifyDatasetFields(modified, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return modified
+}
+
+func TestSqliteData(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_db")
+
+    // db类型数据集
+    wantTypes := map[string]int64{
+        "province": common.DSTypeVar,
+        "city": common.DSTypeVar,
+        "ul_bytes": common.DSTypeInt,
+        "dl_bytes": common.DSTypeInt,
+        "rate": common.DSTypeDEC,
+        "ts": common.DSTypeTime,
+    }
+    if len(fields) != len(wantTypes) {
+        t.Fatalf("fields len %d, want %d", len(fields), len(wantTypes))
+    }
+    for _, field := range fields {
+        if field.DsType != wantTypes[field.Name] {
+            t.Errorf("field [%s] DsType %d, want %d", field.Name, field.DsType, wantTypes[field.Name])
+        }
+    }
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+        Offset: 1,
+        Limit: 2,
+        Sorts: []common.DsSort{{Field: "ul_bytes", Order: "desc"}},
+        Filter: &common.DsFilter{
+            Field: "province",
+            Op: common.FilterOpNe,
+            Values: []interface{}{"上海市"},
+        },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 2 {
+        t.Fatalf("rows len %d, want 2", len(res.TableRow))
+    }
+    if fmt.Sprintf("%v", res.TableRow[0]["ul_bytes"]) != "500" {
+        t.Errorf("first row ul_bytes %v, want 500", res.TableRow[0]["ul_bytes"])
+    }
+
+    // sql类型数据集，表达式列根据样本值推断类型
+    sqlDataset := common.DatasetTable{
+        Name: "flow_sql",
+        DatasourceId: dataset.DatasourceId,
+        Type: "sql",
+        Info: "select province, sum(ul_bytes) + sum(dl_bytes) as total_bytes, avg(rate) as avg_rate from flow group by province",
+    }
+    fields = sqliteDatasetAdd(t, dd, db, &sqlDataset)
+    wantTypes = map[string]int64{
+        "province": common.DSTypeVar,
+        "total_bytes": common.DSTypeInt,
+        "avg_rate": common.DSTypeDEC,
+    }
+    for _, field := range fields {
+        if field.DsType != wantTypes[field.Name] {
+            t.Errorf("field [%s] DsType %d, want %d", field.Name, field.DsType, wantTypes[field.Name])
+        }
+    }
+
+    res, err = dd.GetData(context.Background(), sqlDataset.DatasetId, db, &common.DsQuery{Sorts: []common.DsSort{{Field: "total_bytes", Order: "asc"}}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 3 || len(res.X) != 3 {
+        t.Fatalf("rows len %d, x len %d, want 3", len(res.TableRow), len(res.X))
+    }
+    if res.TableRow[0]["province"] != "北京市" {
+        t.Errorf("first row province %v, want 北京市", res.TableRow[0]["province"])
+    }
+    if fmt.Sprintf("%v", res.TableRow[0]["total_bytes"]) != "1000" {
+        t.Errorf("first row total_bytes %v, want 1000", res.TableRow[0]["total_bytes"])
+    }
+}
+
+// 早期版本创建的元数据库缺少字段表新增的列，CreateDataDriver时补齐
+
+func TestSqliteMetaMigrate(t *testing.T) {
+    db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "meta.db")), &gorm.Config{
+        Logger: logger.Default.LogMode(logger.Silent),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = db.AutoMigrate(&common.DatasourceTable{}, &common.DatasetTable{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = db.Exec("create table dataset_table_field (field_id text primary key, dataset_id text, origin_name text, name text, " +
+        "group_type text, type text, size integer, ds_type integer, ext_field integer, checked integer, column_index integer, " +
+        "last_sync_time datetime, accuracy integer, date_format text, date_format_type text)").Error
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+    for _, column := range []string{"agg_func", "unique_key", "time_granularity"} {
+        if !db.Migrator().HasColumn(&common.DatasetTableField{}, column) {
+            t.Errorf("column [%s] not migrated", column)
+        }
+    }
+
+    datasource := common.DatasourceTable{
+        Name: "sqlite_migrate",
+        Type: "sqlite",
+        Config: common.Configuration{
+            MaxPoolSize: 1,
+            DataBase: sqliteDataInit(t),
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    dataset := common.DatasetTable{
+        Name: "flow_migrate",
+        DatasourceId: datasource.DatasourceId,
+        Type: "db",
+        Info: "flow",
+    }
+    err = dd.AddDataset(&dataset, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fields, err := dd.ScanDatasetFields(dataset.DatasetId, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for index, _ := range fields {
+        if fields[index].Name == "ul_bytes" {
+            fields[index].AggFunc = common.AggMax
+        }
+    }
+    err = dd.ModifyDatasetFields(fields, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var saved common.DatasetTableField
+    err = db.Where("dataset_id = ? and name = ?", dataset.DatasetId, "ul_bytes").First(&saved).Error
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved.AggFunc != common.AggMax {
+        t.Errorf("saved agg_func [%s], want max", saved.AggFunc)
+    }
+}
+
+func TestRegisterDriver(t *testing.T) {
+    handle := db_driver.DBDriverHandle{
+        CreateFunc: db_driver.NewSqliteDriver,
+        Capability: db_driver.DBCapability{Aggregation: true},
+    }
+    err := db_driver.RegisterDriver("sqlite_register_test", handle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 重复注册
+    err = db_driver.RegisterDriver("sqlite_register_test", handle)
+    if err == nil {
+        t.Errorf("duplicate driver name should fail")
+    }
+    err = db_driver.RegisterDriver(db_driver.DatasourceMYSQL, handle)
+    if err == nil {
+        t.Errorf("builtin driver name should fail")
+    }
+    // 兼容旧的DBDriverMap，通过map添加的驱动仍可查到
+    db_driver.DBDriverMap["sqlite_legacy_test"] = handle
+    if _, ok := db_driver.GetDriver("sqlite_legacy_test"); !ok {
+        t.Errorf("legacy DBDriverMap driver not found")
+    }
+    err = db_driver.RegisterDriver("sqlite_legacy_test", handle)
+    if err == nil {
+        t.Errorf("legacy driver name should fail")
+    }
+
+    db := gormSqliteInit(t)
+    dd, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd.Close()
+
+    datasource := common.DatasourceTable{
+        Name: "custom",
+        Type: "sqlite_register_test",
+        Config: common.Configuration{
+            MaxPoolSize: 1,
+            DataBase: sqliteDataInit(t),
+        },
+    }
+    err = dd.AddDatasource(&datasource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    capability, err := dd.GetDatasourceCapability(datasource.DatasourceId)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if capability != handle.Capability {
+        t.Errorf("capability %+v, want %+v", capability, handle.Capability)
+    }
+}
+
+// 过滤条件的sql写法见db_driver中的单元测试，此处确认各操作符在数据库中的结果
+
+func TestSqliteFilter(t *testing.T) {
+    dd, db, dataset, _ := sqliteDatasetInit(t, "flow_db")
+
+    cases := []struct {
+        name    string
+        filter  *common.DsFilter
+        want    int
+    }{
+        {"in", &common.DsFilter{Field: "province", Op: "in", Values: []interface{}{"北京市", "上海市"}}, 3},
+        {"between", &common.DsFilter{Field: "ul_bytes", Op: "between", Values: []interface{}{200, "700"}}, 3},
+        {"time", &common.DsFilter{Field: "ts", Op: "ge", Values: []interface{}{"2023-05-02"}}, 3},
+        {"like", &common.DsFilter{Field: "city", Op: "like", Values: []interface{}{"%州"}}, 1},
+        {"injection", &common.DsFilter{Field: "province", Op: "eq", Values: []interface{}{"x' or '1'='1"}}, 0},
+        {"group", &common.DsFilter{
+            Logic: "or",
+            Children: []common.DsFilter{
+                {Field: "province", Op: "eq", Values: []interface{}{"上海市"}},
+                {
+                    Logic: "and",
+                    Children: []common.DsFilter{
+                        {Field: "province", Op: "eq", Values: []interface{}{"广东省"}},
+                        {Field: "rate", Op: "gt", Values: []interface{}{3}},
+                    },
+                },
+            },
+        }, 2},
+    }
+    for _, c := range cases {
+        res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Filter: c.filter})
+        if err != nil {
+            t.Fatalf("%s: %v", c.name, err)
+        }
+        if len(res.TableRow) != c.want {
+            t.Errorf("%s: rows len %d, want %d", c.name, len(res.TableRow), c.want)
+        }
+    }
+}
+
+func TestSqliteAggregate(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_agg")
+
+    // province为维度，其余字段为指标
+    aggFuncs := map[string]string{
+        "city": common.AggCountDistinct,
+        "ul_bytes": "",
+        "dl_bytes": common.AggAvg,
+        "rate": common.AggMax,
+        "ts": common.AggMin,
+    }
+    fields = sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        if aggFunc, ok := aggFuncs[field.Name]; ok {
+            field.GroupType = common.FieldQuota
+            field.AggFunc = aggFunc
+        }
+    })
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+        Sorts: []common.DsSort{{Field: "ul_bytes", Order: "desc"}},
+        Aggregate: true,
+        Filter: &common.DsFilter{
+            Field: "ul_bytes",
+            Op: common.FilterOpGt,
+            Values: []interface{}{100},
+        },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprintf("%v", res.X) != "[广东省 上海市 北京市]" {
+        t.Fatalf("x %v, want [广东省 上海市 北京市]", res.X)
+    }
+    first := res.TableRow[0]
+    if fmt.Sprintf("%v", first["ul_bytes"]) != "1200" || fmt.Sprintf("%v", first["city"]) != "2" ||
+        fmt.Sprintf("%v", first["dl_bytes"]) != "700" || fmt.Sprintf("%v", first["rate"]) != "3.5" {
+        t.Errorf("first row %v, want 1200/2/700/3.5", first)
+    }
+    // 北京市过滤后只剩一条记录
+    if fmt.Sprintf("%v", res.TableRow[2]["ul_bytes"]) != "300" {
+        t.Errorf("last row %v, want ul_bytes 300", res.TableRow[2])
+    }
+    if len(res.Series) != len(aggFuncs) {
+        t.Errorf("series len %d, want %d", len(res.Series), len(aggFuncs))
+    }
+
+    // 文本字段不能求和
+    sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        if field.Name == "city" {
+            field.AggFunc = common.AggSum
+        }
+    })
+    _, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true})
+    if err == nil {
+        t.Errorf("sum of text field should fail")
+    }
+}
+
+func TestSqliteIdentifier(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    dataset := common.DatasetTable{
+        Name: "user_order",
+        DatasourceId: datasource.DatasourceId,
+        Type: "db",
+        Info: "user order",
+    }
+    sqliteDatasetAdd(t, dd, db, &dataset)
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+        Sorts: []common.DsSort{{Field: "group", Order: "asc"}},
+        Filter: &common.DsFilter{Field: "select count", Op: common.FilterOpGt, Values: []interface{}{0}},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 2 || res.TableRow[0]["group"] != "a" {
+        t.Errorf("rows %v, want group a first", res.TableRow)
+    }
+
+    // 带库名前缀的表名
+    qualified := common.DatasetTable{
+        Name: "flow_main",
+        DatasourceId: datasource.DatasourceId,
+        Type: "db",
+        Info: "main.flow",
+    }
+    sqliteDatasetAdd(t, dd, db, &qualified)
+    res, err = dd.GetData(context.Background(), qualified.DatasetId, db, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 5 {
+        t.Errorf("main.flow rows %d, want 5", len(res.TableRow))
+    }
+
+    // db类型数据集只允许真实存在的表
+    for _, info := range []string{"flow where 1 = 1", "flow; drop table flow", "not_exist", "main.not_exist",
+        "other.flow", "main.flow where 1 = 1", "main.\"flow\"; drop table flow"} {
+        err = dd.AddDataset(&common.DatasetTable{
+            Name: "invalid",
+            DatasourceId: datasource.DatasourceId,
+            Type: "db",
+            Info: info,
+        }, db)
+        if err == nil {
+            t.Errorf("dataset info [%s] should fail", info)
+        }
+    }
+}
+
+// 排序的sql写法见db_driver中的单元测试，此处确认空值位置在数据库中的结果
+
+func TestSqliteSort(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    dataset := common.DatasetTable{
+        Name: "flow_sort",
+        DatasourceId: datasource.DatasourceId,
+        Type: "sql",
+        Info: "select province, city, case when city = '上海' then null else rate end as rate from flow",
+    }
+    sqliteDatasetAdd(t, dd, db, &dataset)
+
+    cases := []struct {
+        name    string
+        sorts   []common.DsSort
+        want    string
+    }{
+        {"multi", []common.DsSort{
+            {Field: "province", Order: common.SortAsc},
+            {Field: "rate", Order: common.SortDesc, Nulls: common.SortNullsFirst},
+        }, "[上海 北京 北京 深圳 广州]"},
+        {"nulls_last", []common.DsSort{
+            {Field: "rate", Order: "ASC", Nulls: common.SortNullsLast},
+        }, "[北京 北京 广州 深圳 上海]"},
+    }
+    for _, c := range cases {
+        res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Sorts: c.sorts})
+        if err != nil {
+            t.Fatalf("%s: %v", c.name, err)
+        }
+        var cities []string
+        for _, row := range res.TableRow {
+            cities = append(cities, fmt.Sprintf("%v", row["city"]))
+        }
+        if fmt.Sprintf("%v", cities) != c.want {
+            t.Errorf("%s: cities %v, want %s", c.name, cities, c.want)
+        }
+    }
+}
+
+func TestSqlitePage(t *testing.T) {
+    dd, db, dataset, _ := sqliteDatasetInit(t, "flow_page")
+
+    filter := &common.DsFilter{Field: "province", Op: common.FilterOpNe, Values: []interface{}{"上海市"}}
+    cases := []struct {
+        name        string
+        query       *common.DsQuery
+        rows        int
+        total       int64
+        hasMore     bool
+    }{
+        {"all", nil, 5, 5, false},
+        {"first_page", &common.DsQuery{Limit: 2}, 2, 5, true},
+        {"last_page", &common.DsQuery{Offset: 4, Limit: 2}, 1, 5, false},
+        {"filter", &common.DsQuery{Offset: 2, Limit: 1, Filter: filter}, 1, 4, true},
+        {"skip_count", &common.DsQuery{Offset: 1, Limit: 2, SkipCount: true}, 2, -1, true},
+        {"aggregate", &common.DsQuery{Limit: 2, Aggregate: true}, 2, 5, true},
+    }
+    for _, c := range cases {
+        res, err := dd.GetData(context.Background(), dataset.DatasetId, db, c.query)
+        if err != nil {
+            t.Fatalf("%s: %v", c.name, err)
+        }
+        if len(res.TableRow) != c.rows || res.Total != c.total || res.HasMore != c.hasMore {
+            t.Errorf("%s: rows %d total %d hasMore %v, want %d %d %v", c.name,
+                len(res.TableRow), res.Total, res.HasMore, c.rows, c.total, c.hasMore)
+        }
+        if c.query != nil && (res.Offset != c.query.Offset || res.Limit != c.query.Limit) {
+            t.Errorf("%s: offset %d limit %d, want %d %d", c.name, res.Offset, res.Limit, c.query.Offset, c.query.Limit)
+        }
+    }
+}
+
+// 游标的编码以及条件写法见db_driver中的单元测试
+// 此处确认排序键含空值以及同一秒内的时间时游标分页不丢行、不重复
+
+func TestSqliteCursor(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    dataDB, err := gorm.Open(sqlite.Open(datasource.Config.DataBase), &gorm.Config{
+        Logger: logger.Default.LogMode(logger.Silent),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    stmts := []string{
+        "create table event (id integer, v integer, ts datetime)",
+        "insert into event values (1, null, '2023-05-01 10:00:00.1')",
+        "insert into event values (2, 5, '2023-05-01 10:00:00.3')",
+        "insert into event values (3, null, '2023-05-01 10:00:00.2')",
+        "insert into event values (4, 3, '2023-05-01 10:00:00.3')",
+        "insert into event values (5, 5, '2023-05-01 10:00:01')",
+    }
+    for _, stmt := range stmts {
+        if err = dataDB.Exec(stmt).Error; err != nil {
+            t.Fatal(err)
+        }
+    }
+    sqlDB, _ := dataDB.DB()
+    _ = sqlDB.Close()
+
+    // id声明为唯一键
+    dataset := common.DatasetTable{
+        Name: "event_cursor",
+        DatasourceId: datasource.DatasourceId,
+        Type: "db",
+        Info: "event",
+    }
+    fields := sqliteDatasetAdd(t, dd, db, &dataset)
+    sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        if field.Name == "id" {
+            field.UniqueKey = 1
+        }
+    })
+
+    cases := []struct {
+        sort    common.DsSort
+        ids     string
+    }{
+        {common.DsSort{Field: "v", Order: common.SortAsc}, "[1 3 4 2 5]"},
+        {common.DsSort{Field: "v", Order: common.SortDesc}, "[2 5 4 1 3]"},
+        {common.DsSort{Field: "v", Order: common.SortAsc, Nulls: common.SortNullsLast}, "[4 2 5 1 3]"},
+        {common.DsSort{Field: "ts", Order: common.SortAsc}, "[1 3 2 4 5]"},
+        {common.DsSort{Field: "ts", Order: common.SortDesc}, "[5 2 4 3 1]"},
+    }
+    for _, c := range cases {
+        var ids []string
+        cursor := ""
+        for page := 0; page < 10; page++ {
+            res, err := dd.GetData(context.Background(), dataset.DatasetId, db,
+                &common.DsQuery{Limit: 2, Sorts: []common.DsSort{c.sort}, Cursor: cursor})
+            if err != nil {
+                t.Fatalf("sort %+v: %v", c.sort, err)
+            }
+            for _, row := range res.TableRow {
+                ids = append(ids, fmt.Sprintf("%v", row["id"]))
+            }
+            if res.HasMore != (res.Cursor != "") {
+                t.Errorf("sort %+v page %d hasMore %v but cursor [%s]", c.sort, page, res.HasMore, res.Cursor)
+            }
+            cursor = res.Cursor
+            if cursor == "" {
+                break
+            }
+        }
+        if fmt.Sprintf("%v", ids) != c.ids {
+            t.Errorf("sort %+v ids %v, want %s", c.sort, ids, c.ids)
+        }
+    }
+
+    // 普通分页的末行排序键为空时同样返回游标
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db,
+        &common.DsQuery{Offset: 1, Limit: 1, Sorts: []common.DsSort{{Field: "v"}}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 1 || res.TableRow[0]["v"] != nil || res.Cursor == "" {
+        t.Errorf("offset page rows %v cursor [%s]", res.TableRow, res.Cursor)
+    }
+
+    // 游标不能与offset同时使用
+    _, err = dd.GetData(context.Background(), dataset.DatasetId, db,
+        &common.DsQuery{Offset: 1, Limit: 1, Sorts: []common.DsSort{{Field: "v"}}, Cursor: res.Cursor})
+    if err == nil {
+        t.Errorf("cursor with offset should fail")
+    }
+}
+
+func TestSqliteProjection(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_projection")
+
+    // 只保留province以及ul_bytes，ul_bytes作为指标
+    sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        switch field.Name {
+        case "province":
+        case "ul_bytes":
+            field.GroupType = common.FieldQuota
+            field.UniqueKey = 1
+        default:
+            field.Checked = 0
+        }
+    })
+
+    // 重新加载确认未选中状态已落库
+    dd2, err := CreateDataDriver(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dd2.Close()
+
+    for _, driver := range []*DataDriver{dd, dd2} {
+        res, err := driver.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+            Limit: 2,
+            Sorts: []common.DsSort{{Field: "dl_bytes", Order: common.SortDesc}},
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(res.Fields) != 2 || len(res.Series) != 1 || res.Cursor == "" {
+            t.Fatalf("fields %d series %d cursor [%s], want 2 1 not empty", len(res.Fields), len(res.Series), res.Cursor)
+        }
+        for _, row := range res.TableRow {
+            if len(row) != 2 {
+                t.Errorf("row %v, want only province and ul_bytes", row)
+            }
+        }
+        if fmt.Sprintf("%v", res.X) != "[上海市 广东省]" || res.Series[0].Data[0].Value != int64(900) {
+            t.Errorf("x %v series %v, want [上海市 广东省] 900", res.X, res.Series)
+        }
+    }
+}
+
+func TestSqliteRename(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_rename")
+
+    renames := map[string]string{"province": "省份", "ul_bytes": "上行流量"}
+    modify := sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        name, ok := renames[field.Name]
+        if !ok {
+            field.Checked = 0
+            return
+        }
+        field.Name = name
+        if name == "上行流量" {
+            field.GroupType = common.FieldQuota
+            field.UniqueKey = 1
+        }
+    })
+
+    filter := &common.DsFilter{Field: "省份", Op: common.FilterOpNe, Values: []interface{}{"上海市"}}
+    sorts := []common.DsSort{{Field: "上行流量", Order: common.SortDesc}}
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 2, Sorts: sorts, Filter: filter})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.TableRow[0]["省份"] != "广东省" || res.TableRow[0]["上行流量"] != int64(700) || res.Cursor == "" {
+        t.Errorf("first row %v cursor [%s], want 广东省 700", res.TableRow[0], res.Cursor)
+    }
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 2, Sorts: sorts, Filter: filter, Cursor: res.Cursor})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 2 || res.TableRow[1]["上行流量"] != int64(100) {
+        t.Errorf("second page %v, want ending with 100", res.TableRow)
+    }
+
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true, Sorts: sorts, Filter: filter})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprintf("%v", res.X) != "[广东省 北京市]" || res.Series[0].Name != "上行流量" {
+        t.Errorf("x %v series %v, want [广东省 北京市] 上行流量", res.X, res.Series)
+    }
+
+    // 字段名重复、与其他字段原始名冲突
+    for _, name := range []string{"省份", "city", ""} {
+        field := modify[len(modify) - 1]
+        field.Name = name
+        err = dd.ModifyDatasetFields([]common.DatasetTableField{field}, db)
+        if err == nil {
+            t.Errorf("rename [%s] to [%s] should fail", modify[len(modify) - 1].Name, name)
+        }
+    }
+}
+
+// 变量的解析、校验以及绑定见db_driver中的单元测试，此处确认取数、计数以及字段发现使用变量
+
+func TestSqliteVariable(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    dataset := common.DatasetTable{
+        Name: "flow_variable",
+        DatasourceId: datasource.DatasourceId,
+        Type: "sql",
+        Info: "select * from flow where province = ${prov} and ul_bytes >= ${min_bytes} and ts >= ${start}",
+        SqlVariableDetails: `[
+            {"name": "prov", "dsType": 0, "default": "广东省", "allowed": ["广东省", "北京市"]},
+            {"name": "min_bytes", "dsType": 2, "default": 0, "required": true},
+            {"name": "start", "dsType": 1, "default": "2023-01-01"}
+        ]`,
+    }
+    sqliteDatasetAdd(t, dd, db, &dataset)
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 2 {
+        t.Errorf("default rows %d, want 2", len(res.TableRow))
+    }
+
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+        Limit: 1,
+        Sorts: []common.DsSort{{Field: "ul_bytes", Order: common.SortDesc}},
+        Filter: &common.DsFilter{Field: "city", Op: common.FilterOpNe, Values: []interface{}{"?"}},
+        Variables: map[string]interface{}{"prov": "北京市", "min_bytes": "100", "start": "2023/05/01"},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 1 || res.TableRow[0]["ul_bytes"] != int64(300) || res.Total != 2 {
+        t.Errorf("rows %v total %d, want ul_bytes 300 total 2", res.TableRow, res.Total)
+    }
+
+    _, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{
+        Variables: map[string]interface{}{"prov": "上海市"},
+    })
+    if err == nil {
+        t.Errorf("variable not allowed should fail")
+    }
+
+    // 必填且无默认值的变量无法完成字段发现
+    err = dd.AddDataset(&common.DatasetTable{
+        Name: "invalid",
+        DatasourceId: datasource.DatasourceId,
+        Type: "sql",
+        Info: dataset.Info,
+        SqlVariableDetails: `[{"name": "prov", "required": true}, {"name": "min_bytes"}, {"name": "start"}]`,
+    }, db)
+    if err == nil {
+        t.Errorf("required variable without default should fail")
+    }
+}
+
+func TestSqliteCalcField(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_calc")
+    modify := sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        field.Checked = 0
+    })
+
+    total := common.DatasetTableField{DatasetId: dataset.DatasetId, Name: "total", OriginName: "ul_bytes + dl_bytes"}
+    err := dd.AddCalcField(&total, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    size := common.DatasetTableField{DatasetId: dataset.DatasetId, Name: "size", OriginName: "if([ul_bytes] > 300, 'big', 'small')"}
+    err = dd.AddCalcField(&size, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total.GroupType != common.FieldQuota || total.DsType != common.DSTypeInt || size.GroupType != common.FieldDimension {
+        t.Errorf("total %s %d size %s, want quota int and dimension", total.GroupType, total.DsType, size.GroupType)
+    }
+
+    filter := &common.DsFilter{Field: "total", Op: common.FilterOpGe, Values: []interface{}{700}}
+    sorts := []common.DsSort{{Field: "total", Order: common.SortDesc}}
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Sorts: sorts, Filter: filter})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 4 || res.TableRow[0]["total"] != int64(1900) || res.TableRow[0]["size"] != "big" {
+        t.Errorf("rows %v, want 4 rows starting with 1900 big", res.TableRow)
+    }
+
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true, Sorts: sorts})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprintf("%v", res.X) != "[big small]" || fmt.Sprintf("%v", res.Series[0].Data[0].Value) != "4500" {
+        t.Errorf("x %v series %v, want [big small] 4500", res.X, res.Series)
+    }
+
+    // 类型错误、字段不存在、引用其他计算字段
+    for _, expr := range []string{"province + 1", "unknown_field * 2", "total / 2", "upper(", ""} {
+        field := common.DatasetTableField{DatasetId: dataset.DatasetId, Name: "bad", OriginName: expr}
+        err = dd.AddCalcField(&field, db)
+        if err == nil {
+            t.Errorf("calculated field [%s] should fail", expr)
+        }
+    }
+
+    // 计算字段引用的字段不能被改名
+    for _, field := range modify {
+        if field.Name == "ul_bytes" {
+            field.Name = "上行流量"
+            err = dd.ModifyDatasetFields([]common.DatasetTableField{field}, db)
+            if err == nil {
+                t.Errorf("rename field referenced by calculated field should fail")
+            }
+        }
+    }
+
+    err = dd.DelCalcField(dataset.DatasetId, total.FieldId, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = dd.DelCalcField(dataset.DatasetId, modify[0].FieldId, db)
+    if err == nil {
+        t.Errorf("delete origin field should fail")
+    }
+}
+
+func TestSqliteTimeBucket(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_time")
+    modify := sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        switch field.Name {
+        case "ts":
+            field.GroupType = common.FieldDimension
+            field.TimeGranularity = common.TimeGranDay
+        case "ul_bytes":
+            field.GroupType = common.FieldQuota
+        default:
+            field.Checked = 0
+        }
+    })
+
+    sorts := []common.DsSort{{Field: "ts"}}
+    cases := []struct {
+        gran    map[string]string
+        x       string
+        value   string
+    }{
+        {nil, "[2023-05-01 2023-05-02 2023-05-03]", "400"},
+        {map[string]string{"ts": common.TimeGranHour}, "[2023-05-01 10:00 2023-05-01 11:00 2023-05-02 10:00 2023-05-03 10:00 2023-05-03 12:00]", "100"},
+        {map[string]string{"ts": common.TimeGranWeek}, "[2023-05-01]", "2500"},
+        {map[string]string{"ts": common.TimeGranQuarter}, "[2023-Q2]", "2500"},
+        {map[string]string{"ts": common.TimeGranYear}, "[2023]", "2500"},
+    }
+    for _, c := range cases {
+        res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true, Sorts: sorts, Granularity: c.gran})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if fmt.Sprintf("%v", res.X) != c.x || fmt.Sprintf("%v", res.Series[0].Data[0].Value) != c.value {
+            t.Errorf("granularity %v x %v series %v, want %s %s", c.gran, res.X, res.Series, c.x, c.value)
+        }
+    }
+
+    // 自定义标签格式
+    for index, _ := range modify {
+        if modify[index].Name == "ts" {
+            modify[index].TimeGranularity = common.TimeGranMonth
+            modify[index].DateFormatType = common.DateFormatTypeCustom
+            modify[index].DateFormat = "yyyy年MM月"
+        }
+    }
+    err := dd.ModifyDatasetFields(modify, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true, Sorts: sorts})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprintf("%v", res.X) != "[2023年05月]" {
+        t.Errorf("x %v, want [2023年05月]", res.X)
+    }
+
+    // 非聚合查询、不支持的粒度、非时间字段
+    grans := []*common.DsQuery{
+        {Granularity: map[string]string{"ts": common.TimeGranDay}},
+        {Aggregate: true, Granularity: map[string]string{"ts": "second"}},
+        {Aggregate: true, Granularity: map[string]string{"ul_bytes": common.TimeGranDay}},
+        {Aggregate: true, Granularity: map[string]string{"unknown": common.TimeGranDay}},
+    }
+    for _, query := range grans {
+        _, err = dd.GetData(context.Background(), dataset.DatasetId, db, query)
+        if err == nil {
+            t.Errorf("granularity %v aggregate %v should fail", query.Granularity, query.Aggregate)
+        }
+    }
+}
+
+func TestSqliteTopN(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_top")
+    sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        switch field.Name {
+        case "province":
+            field.GroupType = common.FieldDimension
+        case "ul_bytes":
+            field.GroupType = common.FieldQuota
+        case "dl_bytes":
+            field.GroupType = common.FieldQuota
+            field.AggFunc = common.AggAvg
+        default:
+            field.Checked = 0
+        }
+    })
+
+    quotaValues := func(res *common.DsResult, name string) string {
+        for _, series := range res.Series {
+            if series.Name == name {
+                var values []string
+                for _, data := range series.Data {
+                    values = append(values, fmt.Sprintf("%v", data.Value))
+                }
+                return strings.Join(values, " ")
+            }
+        }
+        return ""
+    }
+
+    filter := &common.DsFilter{Field: "city", Op: common.FilterOpNe, Values: []interface{}{"深圳"}}
+    cases := []struct {
+        topN    common.DsTopN
+        filter  *common.DsFilter
+        x       string
+        ul      string
+        dl      string
+    }{
+        {common.DsTopN{N: 1, Field: "ul_bytes"}, nil, "[广东省 Others]", "1200 1300", "700 533.3333333333334"},
+        {common.DsTopN{N: 2, Field: "ul_bytes", OthersName: "其他"}, nil, "[广东省 上海市 其他]", "1200 900 400", "700 1000 300"},
+        {common.DsTopN{N: 1, Field: "ul_bytes", Order: common.SortAsc}, nil, "[北京市 Others]", "400 2100", "300 800"},
+        {common.DsTopN{N: 3, Field: "ul_bytes"}, nil, "[广东省 上海市 北京市]", "1200 900 400", "700 1000 300"},
+        {common.DsTopN{N: 1, Field: "ul_bytes"}, filter, "[上海市 Others]", "900 900", "1000 400"},
+    }
+    for _, c := range cases {
+        topN := c.topN
+        res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{TopN: &topN, Filter: c.filter})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if fmt.Sprintf("%v", res.X) != c.x || quotaValues(res, "ul_bytes") != c.ul || quotaValues(res, "dl_bytes") != c.dl || res.Total != int64(len(res.X)) {
+            t.Errorf("top %v x %v ul [%s] dl [%s] total %d, want %s [%s] [%s]", c.topN, res.X,
+                quotaValues(res, "ul_bytes"), quotaValues(res, "dl_bytes"), res.Total, c.x, c.ul, c.dl)
+        }
+    }
+
+    // 参数错误：N非法、排名字段不是指标、带分页
+    queries := []*common.DsQuery{
+        {TopN: &common.DsTopN{N: 0, Field: "ul_bytes"}},
+        {TopN: &common.DsTopN{N: 1, Field: "province"}},
+        {TopN: &common.DsTopN{N: 1, Field: "ul_bytes"}, Limit: 10},
+    }
+    for _, query := range queries {
+        _, err := dd.GetData(context.Background(), dataset.DatasetId, db, query)
+        if err == nil {
+            t.Errorf("top n %v limit %d should fail", *query.TopN, query.Limit)
+        }
+    }
+}
+
+func TestSqlitePivot(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_pivot")
+    sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        if field.Name == "dl_bytes" {
+            field.GroupType = common.FieldQuota
+            field.AggFunc = common.AggAvg
+        }
+    })
+
+    // 按行输出行头以及各列第measure个指标值，无数据为-
+    pivotText := func(pivot *common.PivotResult, measure int) string {
+        var lines []string
+        for i, row := range pivot.Rows {
+            line := fmt.Sprintf("%d%v", row.Level, row.Values)
+            for j, _ := range pivot.Columns {
+                cell := pivot.Cells[i][j]
+                if cell == nil {
+                    line += " -"
+                } else {
+                    line += fmt.Sprintf(" %v", cell[measure])
+                }
+            }
+            lines = append(lines, line)
+        }
+        return strings.Join(lines, ";")
+    }
+
+    pivot := &common.DsPivot{Rows: []string{"province", "city"}, Measures: []string{"ul_bytes", "dl_bytes"}, Subtotal: true}
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Pivot: pivot})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := "2[上海市 上海] 900;1[上海市] 900;2[北京市 北京] 400;1[北京市] 400;2[广东省 广州] 500;2[广东省 深圳] 700;1[广东省] 1200;0[] 2500"
+    if text := pivotText(res.Pivot, 0); text != want {
+        t.Errorf("pivot ul\n%s\nwant\n%s", text, want)
+    }
+    want = "2[上海市 上海] 1000;1[上海市] 1000;2[北京市 北京] 300;1[北京市] 300;2[广东省 广州] 600;2[广东省 深圳] 800;1[广东省] 700;0[] 600"
+    if text := pivotText(res.Pivot, 1); text != want {
+        t.Errorf("pivot dl avg\n%s\nwant\n%s", text, want)
+    }
+
+    pivot = &common.DsPivot{Rows: []string{"province"}, Columns: []string{"ts"}, Measures: []string{"ul_bytes"}}
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Pivot: pivot, Granularity: map[string]string{"ts": common.TimeGranDay}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprintf("%v", res.Pivot.Columns) != "[{[2023-05-01] 1} {[2023-05-02] 1} {[2023-05-03] 1} {[] 0}]" {
+        t.Errorf("pivot columns %v", res.Pivot.Columns)
+    }
+    want = "1[上海市] - - 900 900;1[北京市] 400 - - 400;1[广东省] - 500 700 1200;0[] 400 500 1600 2500"
+    if text := pivotText(res.Pivot, 0); text != want || res.Total != 4 {
+        t.Errorf("pivot by day\n%s\nwant\n%s", text, want)
+    }
+
+    // 空值与文本"<nil>"是不同的行
+    nullDataset := common.DatasetTable{
+        Name: "flow_pivot_null",
+        DatasourceId: dataset.DatasourceId,
+        Type: "sql",
+        Info: "select city, ul_bytes from flow where province = '上海市' union all select null, 10 union all select '<nil>', 20",
+    }
+    nullFields := sqliteDatasetAdd(t, dd, db, &nullDataset)
+    sqliteFieldsModify(t, dd, db, nullFields, func(field *common.DatasetTableField) {
+        if field.Name == "ul_bytes" {
+            field.GroupType = common.FieldQuota
+        }
+    })
+    pivot = &common.DsPivot{Rows: []string{"city"}, Measures: []string{"ul_bytes"}}
+    res, err = dd.GetData(context.Background(), nullDataset.DatasetId, db, &common.DsQuery{Pivot: pivot})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want = "1[<nil>] 10;1[<nil>] 20;1[上海] 900;0[] 930"
+    if text := pivotText(res.Pivot, 0); text != want {
+        t.Errorf("pivot null\n%s\nwant\n%s", text, want)
+    }
+
+    // 字段不存在、重复、带分页
+    queries := []*common.DsQuery{
+        {Pivot: &common.DsPivot{Rows: []string{"unknown"}}},
+        {Pivot: &common.DsPivot{Rows: []string{"province"}, Columns: []string{"province"}}},
+        {Pivot: &common.DsPivot{Rows: []string{"province"}, Measures: []string{"province"}}},
+        {Pivot: &common.DsPivot{Rows: []string{"province"}}, Limit: 10},
+    }
+    for _, query := range queries {
+        _, err = dd.GetData(context.Background(), dataset.DatasetId, db, query)
+        if err == nil {
+            t.Errorf("pivot %v limit %d should fail", *query.Pivot, query.Limit)
+        }
+    }
+}
+
+func TestSqliteCompare(t *testing.T) {
+    dd, db, dataset, fields := sqliteDatasetInit(t, "flow_compare")
+    sqliteFieldsModify(t, dd, db, fields, func(field *common.DatasetTableField) {
+        switch field.Name {
+        case "ts":
+            field.GroupType = common.FieldDimension
+            field.TimeGranularity = common.TimeGranDay
+        case "ul_bytes":
+            field.GroupType = common.FieldQuota
+        default:
+            field.Checked = 0
+        }
+    })
+
+    compareText := func(res *common.DsResult) string {
+        var items []string
+        for index, data := range res.Series[0].Data {
+            items = append(items, fmt.Sprintf("%s:%v/%v/%v/%v", res.X[index], data.Value, data.CompareValue, data.Change, data.ChangeRate))
+        }
+        return strings.Join(items, " ")
+    }
+
+    sorts := []common.DsSort{{Field: "ts"}}
+    dayCompare := &common.DsCompare{Field: "ts", Period: common.TimeGranDay}
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Sorts: sorts, Compare: dayCompare})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := "2023-05-01:400/<nil>/<nil>/<nil> 2023-05-02:500/400/100/0.25 2023-05-03:1600/500/1100/2.2"
+    if text := compareText(res); text != want || res.Total != 3 {
+        t.Errorf("day compare %s total %d, want %s", text, res.Total, want)
+    }
+
+    // 过滤条件作用于上一周期数据原始的时间，05-01的数据被过滤掉，05-02没有对比值
+    filter := &common.DsFilter{Field: "ts", Op: common.FilterOpGe, Values: []interface{}{"2023-05-02 00:00:00"}}
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true, Sorts: sorts, Compare: dayCompare, Filter: filter})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want = "2023-05-02:500/<nil>/<nil>/<nil> 2023-05-03:1600/500/1100/2.2"
+    if text := compareText(res); text != want {
+        t.Errorf("filtered day compare %s, want %s", text, want)
+    }
+
+    res, err = dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 2, Sorts: sorts, Compare: &common.DsCompare{Field: "ts", Period: common.TimeGranWeek}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want = "2023-05-01:400/<nil>/<nil>/<nil> 2023-05-02:500/<nil>/<nil>/<nil>"
+    if text := compareText(res); text != want || res.Total != 3 || !res.HasMore {
+        t.Errorf("week compare %s total %d, want %s total 3", text, res.Total, want)
+    }
+
+    // 对比字段不是分桶的时间维度、周期不支持
+    queries := []*common.DsQuery{
+        {Compare: &common.DsCompare{Field: "ul_bytes", Period: common.TimeGranDay}},
+        {Compare: &common.DsCompare{Field: "ts", Period: "second"}},
+        {Compare: &common.DsCompare{Field: "ts", Period: common.TimeGranDay}, Granularity: map[string]string{"ts": ""}},
+    }
+    for _, query := range queries {
+        _, err = dd.GetData(context.Background(), dataset.DatasetId, db, query)
+        if err == nil {
+            t.Errorf("compare %v should fail", *query.Compare)
+        }
+    }
+}
+
+func TestSqliteFieldValues(t *testing.T) {
+    dd, db, dataset, _ := sqliteDatasetInit(t, "flow_values")
+
+    valuesText := func(values []common.DsFieldValue) string {
+        var items []string
+        for _, value := range values {
+            items = append(items, fmt.Sprintf("%s:%d", value.Label, value.Count))
+        }
+        return strings.Join(items, " ")
+    }
+
+    filter := &common.DsFilter{Field: "city", Op: common.FilterOpNe, Values: []interface{}{"深圳"}}
+    cases := []struct {
+        field   string
+        search  string
+        limit   int
+        options *common.DsValueOptions
+        want    string
+    }{
+        {"province", "", 0, nil, "北京市:2 广东省:2 上海市:1"},
+        {"province", "", 1, nil, "北京市:2"},
+        {"province", "广", 0, nil, "广东省:2"},
+        {"province", "京", 0, nil, ""},
+        {"province", "京", 0, &common.DsValueOptions{Match: common.ValueMatchContains}, "北京市:2"},
+        {"province", "%", 0, &common.DsValueOptions{Match: common.ValueMatchContains}, ""},
+        {"province", "", 0, &common.DsValueOptions{Filter: filter}, "北京市:2 上海市:1 广东省:1"},
+        {"ts", "", 2, nil, "2023-05-01 10:00:00:1 2023-05-01 11:00:00:1"},
+    }
+    for _, c := range cases {
+        values, err := dd.GetFieldValues(context.Background(), dataset.DatasetId, db, c.field, c.search, c.limit, c.options)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if text := valuesText(values); text != c.want {
+            t.Errorf("field [%s] search [%s] values [%s], want [%s]", c.field, c.search, text, c.want)
+        }
+    }
+
+    // sql类型数据集按变量取值
+    sqlDataset := common.DatasetTable{
+        Name: "flow_values_variable",
+        DatasourceId: dataset.DatasourceId,
+        Type: "sql",
+        Info: "select * from flow where province = ${prov}",
+        SqlVariableDetails: `[{"name": "prov", "dsType": 0, "default": "广东省"}]`,
+    }
+    sqliteDatasetAdd(t, dd, db, &sqlDataset)
+    values, err := dd.GetFieldValues(context.Background(), sqlDataset.DatasetId, db, "city", "", 0, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if text := valuesText(values); text != "广州:1 深圳:1" {
+        t.Errorf("default variable values [%s], want [广州:1 深圳:1]", text)
+    }
+    values, err = dd.GetFieldValues(context.Background(), sqlDataset.DatasetId, db, "city", "", 0, &common.DsValueOptions{Variables: map[string]interface{}{"prov": "北京市"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if text := valuesText(values); text != "北京:2" {
+        t.Errorf("variable values [%s], want [北京:2]", text)
+    }
+
+    // 字段不存在、非文本字段搜索、搜索方式不支持
+    _, err = dd.GetFieldValues(context.Background(), dataset.DatasetId, db, "unknown", "", 0, nil)
+    if err == nil {
+        t.Errorf("unknown field should fail")
+    }
+    _, err = dd.GetFieldValues(context.Background(), dataset.DatasetId, db, "ul_bytes", "1", 0, nil)
+    if err == nil {
+        t.Errorf("search number field should fail")
+    }
+    _, err = dd.GetFieldValues(context.Background(), dataset.DatasetId, db, "province", "北", 0, &common.DsValueOptions{Match: "regex"})
+    if err == nil {
+        t.Errorf("match regex should fail")
+    }
+}
+
+func TestSqliteProfile(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    dataset := common.DatasetTable{
+        Name: "flow_profile",
+        DatasourceId: datasource.DatasourceId,
+        Type: "sql",
+        Info: "select * from flow union all select null, null, null, null, null, null",
+    }
+    fields := sqliteDatasetAdd(t, dd, db, &dataset)
+
+    profiles, err := dd.ProfileDataset(context.Background(), dataset.DatasetId, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(profiles) != len(fields) {
+        t.Fatalf("profiles %d, want %d", len(profiles), len(fields))
+    }
+    want := map[string]string{
+        "province": "6 1 3 上海市 广东省 <nil> [北京市:2 广东省:2 <nil>:1 上海市:1]",
+        "ul_bytes": "6 1 5 100 900 500 [<nil>:1 100:1 300:1 500:1 700:1]",
+    }
+    for _, field := range fields {
+        w, ok := want[field.Name]
+        if !ok {
+            continue
+        }
+        profile := profiles[field.FieldId]
+        var top []string
+        for _, value := range profile.TopValues {
+            top = append(top, fmt.Sprintf("%s:%d", value.Label, value.Count))
+        }
+        text := fmt.Sprintf("%d %d %d %v %v %v %v", profile.RowCount, profile.NullCount, profile.DistinctCount,
+            profile.Min, profile.Max, profile.Mean, top)
+        if text != w || profile.DistinctApprox || profile.Name != field.Name {
+            t.Errorf("field [%s] profile [%s], want [%s]", field.Name, text, w)
+        }
+    }
+}
+
+func TestSqliteContext(t *testing.T) {
+    dd, db, dataset, _ := sqliteDatasetInit(t, "flow_ctx")
+
+    // 已取消的ctx中止查询
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    _, err := dd.GetData(ctx, dataset.DatasetId, db, &common.DsQuery{Aggregate: true})
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("canceled GetData err [%v], want context canceled", err)
+    }
+    _, err = dd.QueryDataByTable(ctx, *dataset, nil)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("canceled QueryDataByTable err [%v], want context canceled", err)
+    }
+
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(res.TableRow) != 1 {
+        t.Errorf("rows %d, want 1", len(res.TableRow))
+    }
+}
+
+// 超过数据源QueryTimeout或调用方取消ctx时中止执行中的查询
+
+func TestSqliteQueryTimeout(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    timeoutSource := common.DatasourceTable{
+        Name: "sqlite_timeout",
+        Type: "sqlite",
+        Config: datasource.Config,
+    }
+    timeoutSource.Config.QueryTimeout = 1
+    err := dd.AddDatasource(&timeoutSource, db)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    slowSql := "with recursive t(n) as (select 1 union all select n + 1 from t where n < 200000000) select 'g' || (n % 7) as g, n from t"
+    slow := common.DatasetTable{
+        DatasourceId: timeoutSource.DatasourceId,
+        Type: "sql",
+        Info: slowSql,
+    }
+    start := time.Now()
+    _, err = dd.QueryDataByTable(context.Background(), slow, &common.DsQuery{Aggregate: true})
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("slow query err [%v], want deadline exceeded", err)
+    }
+    if elapsed := time.Since(start); elapsed > 10 * time.Second {
+        t.Errorf("slow query returned after %v, want about 1s", elapsed)
+    }
+
+    // 调用方在查询执行中取消ctx
+    slow.DatasourceId = datasource.DatasourceId
+    ctx, cancel := context.WithCancel(context.Background())
+    timer := time.AfterFunc(200 * time.Millisecond, cancel)
+    defer timer.Stop()
+    start = time.Now()
+    _, err = dd.QueryDataByTable(ctx, slow, &common.DsQuery{Aggregate: true})
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("canceled query err [%v], want context canceled", err)
+    }
+    if elapsed := time.Since(start); elapsed > 10 * time.Second {
+        t.Errorf("canceled query returned after %v, want about 200ms", elapsed)
+    }
+}
+
+func TestSqliteRunningQuery(t *testing.T) {
+    dd, db, datasource := sqliteDriverInit(t)
+
+    // 递归生成大量行，聚合耗时足够在执行中取消
+    dataset := common.DatasetTable{
+        Name: "flow_slow",
+        DatasourceId: datasource.DatasourceId,
+        Type: "sql",
+        Info: "with recursive t(n) as (select 1 union all select n + 1 from t where n < 200000000) select 'g' || (n % 7) as g, n from t",
+    }
+    sqliteDatasetAdd(t, dd, db, &dataset)
+
+    errCh := make(chan error, 1)
+    go func() {
+        _, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Aggregate: true, QueryId: "slow", Caller: "tester"})
+        errCh <- err
+    }()
+
+    var running []common.RunningQuery
+    for i := 0; i < 100 && (len(running) == 0 || running[0].Sql == ""); i++ {
+        time.Sleep(10 * time.Millisecond)
+        running = dd.ListRunningQueries()
+    }
+    if len(running) != 1 || running[0].Sql == "" {
+        t.Fatalf("running queries %+v, want slow query", running)
+    }
+    q := running[0]
+    if q.QueryId != "slow" || q.Caller != "tester" || q.DatasetId != dataset.DatasetId ||
+        q.DatasourceId != datasource.DatasourceId || q.StartTime.IsZero() {
+        t.Errorf("running query %+v", q)
+    }
+
+    // 相同查询ID不能重复执行
+    _, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{QueryId: "slow"})
+    if err == nil {
+        t.Errorf("duplicate query id should fail")
+    }
+
+    err = dd.CancelQuery("slow")
+    if err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case err = <-errCh:
+        if !errors.Is(err, context.Canceled) {
+            t.Errorf("canceled query err [%v], want context canceled", err)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatal("query not canceled")
+    }
+    if running = dd.ListRunningQueries(); len(running) != 0 {
+        t.Errorf("running queries %+v after cancel, want empty", running)
+    }
+    if err = dd.CancelQuery("slow"); err == nil {
+        t.Errorf("cancel finished query should fail")
+    }
+
+    // 取数很快而计数耗时，登记的语句仍为取数语句
+    go func() {
+        _, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 1, QueryId: "slow_count"})
+        errCh <- err
+    }()
+    running = nil
+    for i := 0; i < 100 && (len(running) == 0 || running[0].Sql == ""); i++ {
+        time.Sleep(10 * time.Millisecond)
+        running = dd.ListRunningQueries()
+    }
+    // 等待计数语句开始执行
+    time.Sleep(50 * time.Millisecond)
+    running = dd.ListRunningQueries()
+    if len(running) != 1 || running[0].Sql == "" || strings.Contains(running[0].Sql, "count(*)") {
+        t.Errorf("running queries %+v, want data statement", running)
+    }
+    err = dd.CancelQuery("slow_count")
+    if err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case err = <-errCh:
+        if !errors.Is(err, context.Canceled) {
+            t.Errorf("canceled query err [%v], want context canceled", err)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatal("query not canceled")
+    }
+
+    // 未指定查询ID时自动生成并在结果中给出
+    flow := common.DatasetTable{
+        Name: "flow_id",
+        DatasourceId: datasource.DatasourceId,
+        Type: "db",
+        Info: "flow",
+    }
+    res, err := dd.QueryDataByTable(context.Background(), flow, &common.DsQuery{Limit: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.QueryId == "" {
+        t.Errorf("result query id is empty")
+    }
+}
@@ -1,6 +1,7 @@
 package data_driver
 
 import (
+    "context"
     "encoding/json"
     "fmt"
     "github.com/bingLAN/data_driver/common"
@@ -75,7 +76,7 @@ func TestData(t *testing.T) {
     }
 
     // 查看数据
-    res, err := dd.GetData(dataset.DatasetId, db, 0, 1000, nil, "", "")
+    res, err := dd.GetData(context.Background(), dataset.DatasetId, db, &common.DsQuery{Limit: 1000})
     if err != nil {
         t.Fatal(err)
     }
@@ -104,7 +105,14 @@ func TestQuery(t *testing.T) {
     }
     fmt.Println(s)
     
-    res, err := dd.GetData("46b8ed35-9fa0-43b7-8249-3f4860516890", db, 0, 1000, nil, "", "server_prov_str='北京市'")
+    res, err := dd.GetData(context.Background(), "46b8ed35-9fa0-43b7-8249-3f4860516890", db, &common.DsQuery{
+        Limit: 1000,
+        Filter: &common.DsFilter{
+            Field: "server_prov_str",
+            Op: common.FilterOpEq,
+            Values: []interface{}{"北京市"},
+        },
+    })
     if err != nil {
         t.Fatal(err)
     }
@@ -1,6 +1,7 @@
 package dataset
 
 import (
+    "context"
     "errors"
     "fmt"
     "github.com/bingLAN/data_driver/common"
@@ -16,8 +17,9 @@ type Dataset struct {
     Datasource  *datasource.Datasource
 }
 
-func (ds *Dataset) GetData(datasetId string, db *gorm.DB, offset, limit int, sortNames []string, sortOpt string, filter string) (*common.DsResult, error) {
-    // 查看数据源是否可用
+// 查看数据源是否可用，不可用时尝试恢复连接
+
+func (ds *Dataset) connCheck(db *gorm.DB) error {
     status := ds.Datasource.DBDriver.GetDBConnStatus()
     if status != db_driver.ConnSuccess {
         // 尝试恢复连接
@@ -26,15 +28,54 @@ func (ds *Dataset) GetData(datasetId string, db *gorm.DB, offset, limit int, sor
             // 连接恢复成功，更新数据库状态
             errDB := db.Model(&common.DatasourceTable{}).Where("datasource_id = ?", ds.DatasetInfo.DatasourceId).Update("status", db_driver.ConnSuccess).Error
             if errDB != nil {
-                return nil, err
+                return err
             }
         } else {
-            return nil, errors.New(fmt.Sprintf("datasource not available!"))
+            return errors.New(fmt.Sprintf("datasource not available!"))
         }
     }
+
+    return nil
+}
+
+func (ds *Dataset) GetData(ctx context.Context, datasetId string, db *gorm.DB, query *common.DsQuery) (*common.DsResult, error) {
+    if query != nil && query.NeedAggregation() && !ds.Datasource.Capability().Aggregation {
+        return nil, errors.New(fmt.Sprintf("datasource of dataset [%s] not support aggregate", ds.DatasetInfo.Name))
+    }
+
+    err := ds.connCheck(db)
+    if err != nil {
+        return nil, err
+    }
     
     // 调用db_driver的接口
-    return ds.Datasource.DBDriver.GetData(datasetId, ds.DatasetInfo, ds.Fields.fields, offset, limit, sortNames, sortOpt, filter)
+    return ds.Datasource.DBDriver.GetData(ctx, datasetId, ds.DatasetInfo, ds.Fields.fields, query)
+}
+
+func (ds *Dataset) GetFieldValues(ctx context.Context, db *gorm.DB, fieldName string, search string, limit int, options *common.DsValueOptions) ([]common.DsFieldValue, error) {
+    if !ds.Datasource.Capability().Aggregation {
+        return nil, errors.New(fmt.Sprintf("datasource of dataset [%s] not support aggregate", ds.DatasetInfo.Name))
+    }
+
+    err := ds.connCheck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    return ds.Datasource.DBDriver.GetFieldValues(ctx, ds.DatasetInfo, ds.Fields.fields, fieldName, search, limit, options)
+}
+
+func (ds *Dataset) ProfileFields(ctx context.Context, db *gorm.DB) (map[string]common.FieldProfile, error) {
+    if !ds.Datasource.Capability().Aggregation {
+        return nil, errors.New(fmt.Sprintf("datasource of dataset [%s] not support aggregate", ds.DatasetInfo.Name))
+    }
+
+    err := ds.connCheck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    return ds.Datasource.DBDriver.GetFieldProfiles(ctx, ds.DatasetInfo, ds.Fields.fields)
 }
 
 func (ds *Dataset) GetFields() []common.DatasetTableField {
@@ -61,6 +102,9 @@ func (d *Datasets) GetDatasetById(datasetId string) (*Dataset, error) {
     return s.(*Dataset), nil
 }
 
+// 允许修改的field列，调用方需传入完整的field(如ScanDatasetFields的结果)
+var datasetFieldModifyColumns = []string{"name", "group_type", "checked", "column_index", "agg_func", "unique_key", "date_format", "date_format_type", "time_granularity"}
+
 func (d *Datasets) ModifyDatasetFields(fields []common.DatasetTableField, db *gorm.DB) error {
     if len(fields) == 0 {
         return nil
@@ -72,6 +116,12 @@ func (d *Datasets) ModifyDatasetFields(fields []common.DatasetTableField, db *go
     }
     dataset := s.(*Dataset)
 
+    // 字段名用于展示以及排序、过滤，修改后需保持唯一，计算字段引用的字段需仍然存在
+    err := fieldsCheck(fieldsMerge(dataset.Fields.fields, fields))
+    if err != nil {
+        return err
+    }
+
     // 建立map同时同步db
 
    tx := db.Begin()
@@ -81,8 +131,9 @@ func (d *Datasets) ModifyDatasetFields(fields []common.DatasetTableField, db *go
         fieldId := fields[index].FieldId
         fieldsMap[fieldId] = index
 
-        // 注意：updates只会更新非0字段
-        err := tx.Model(&common.DatasetTableField{}).Where("field_id = ?", fields[index].FieldId).Updates(fields[index]).Error
+        // 注意：updates只会更新非0字段，这里指定可修改的列，未选中(checked=0)等零值也需要落库
+        err := tx.Model(&common.DatasetTableField{}).Where("field_id = ?", fields[index].FieldId).
+            Select(datasetFieldModifyColumns).Updates(fields[index]).Error
         if err != nil {
             tx.Rollback()
             return err
@@ -93,7 +144,15 @@ func (d *Datasets) ModifyDatasetFields(fields []common.DatasetTableField, db *go
     // 同步cache
     for index, _ := range dataset.Fields.fields {
         if index2, ok := fieldsMap[dataset.Fields.fields[index].FieldId]; ok {
+            dataset.Fields.fields[index].Name = fields[index2].Name
             dataset.Fields.fields[index].GroupType = fields[index2].GroupType
+            dataset.Fields.fields[index].Checked = fields[index2].Checked
+            dataset.Fields.fields[index].ColumnIndex = fields[index2].ColumnIndex
+            dataset.Fields.fields[index].AggFunc = fields[index2].AggFunc
+            dataset.Fields.fields[index].UniqueKey = fields[index2].UniqueKey
+            dataset.Fields.fields[index].DateFormat = fields[index2].DateFormat
+            dataset.Fields.fields[index].DateFormatType = fields[index2].DateFormatType
+            dataset.Fields.fields[index].TimeGranularity = fields[index2].TimeGranularity
         }
     }
 
@@ -103,6 +162,85 @@ func (d *Datasets) ModifyDatasetFields(fields []common.DatasetTableField, db *go
 }
 
 
+// 添加计算字段，field.OriginName为表达式，表达式通过检查后按结果类型确定DsType
+
+func (d *Datasets) AddCalcField(field *common.DatasetTableField, db *gorm.DB) error {
+    s, ok := d.datasetMap.Get(field.DatasetId)
+    if !ok {
+        return errors.New(fmt.Sprintf("datasetMap doesn't have [%s] dataset", field.DatasetId))
+    }
+    dataset := s.(*Dataset)
+
+    dsType, err := db_driver.ExprCheck(field.OriginName, dataset.Fields.fields)
+    if err != nil {
+        return err
+    }
+
+    var columnIndex int64
+    for index, _ := range dataset.Fields.fields {
+        if dataset.Fields.fields[index].ColumnIndex >= columnIndex {
+            columnIndex = dataset.Fields.fields[index].ColumnIndex + 1
+        }
+    }
+    field.FieldId = createDatasetFieldId()
+    field.ExtField = 1
+    field.DsType = dsType
+    field.Type = "expression"
+    field.Checked = 1
+    field.ColumnIndex = columnIndex
+    if field.GroupType == "" {
+        field.GroupType = common.FieldDimension
+        if dsType == common.DSTypeInt || dsType == common.DSTypeDEC {
+            field.GroupType = common.FieldQuota
+        }
+    }
+
+    err = fieldsCheck(append(fieldsMerge(dataset.Fields.fields, nil), *field))
+    if err != nil {
+        return err
+    }
+
+    err = db.Model(&common.DatasetTableField{}).Create(field).Error
+    if err != nil {
+        return err
+    }
+    dataset.Fields.fields = append(dataset.Fields.fields, *field)
+
+    return nil
+}
+
+// 删除计算字段，数据源字段不能删除
+
+func (d *Datasets) DelCalcField(datasetId string, fieldId string, db *gorm.DB) error {
+    s, ok := d.datasetMap.Get(datasetId)
+    if !ok {
+        return errors.New(fmt.Sprintf("datasetMap doesn't have [%s] dataset", datasetId))
+    }
+    dataset := s.(*Dataset)
+
+    for index, _ := range dataset.Fields.fields {
+        field := dataset.Fields.fields[index]
+        if field.FieldId != fieldId {
+            continue
+        }
+        if field.ExtField != 1 {
+            return errors.New(fmt.Sprintf("field [%s] is not a calculated field", field.Name))
+        }
+
+        err := db.Where("field_id = ?", fieldId).Delete(&common.DatasetTableField{}).Error
+        if err != nil {
+            return err
+        }
+        fields := append([]common.DatasetTableField(nil), dataset.Fields.fields[:index]...)
+        dataset.Fields.fields = append(fields, dataset.Fields.fields[index + 1:]...)
+
+        return nil
+    }
+
+    return errors.New(fmt.Sprintf("dataset [%s] doesn't have field [%s]", datasetId, fieldId))
+}
+
+
 // DatasetCacheInit 加载数据集全表
 // db后端数据库句柄
 
@@ -158,8 +296,8 @@ func (d *Datasets) createDataset(dsTable *common.DatasetTable) (*Dataset, error)
         return nil, err
     }
     
-    // 调用驱动层获取fields, fieldId已在驱动层填充
-    fields, err := datasource.DBDriver.GetDataFields(*dsTable)
+    // 调用驱动层获取fields, fieldId已在驱动层填充，仅受数据源查询超时限制
+    fields, err := datasource.DBDriver.GetDataFields(context.Background(), *dsTable)
     if err != nil {
         return nil, err
     }
@@ -312,9 +450,34 @@ func (d *Datasets) DatasetModify(dsTable common.DatasetTable, db *gorm.DB) error
 
 
 
+// 字段表在早期版本之后新增的列，已有的元数据库中缺失时补齐
+// 只增加缺失的列，不修改已有列的定义
+
+var datasetFieldAddedColumns = []string{"AggFunc", "UniqueKey", "TimeGranularity"}
+
+func datasetFieldMigrate(db *gorm.DB) error {
+    migrator := db.Migrator()
+    for _, column := range datasetFieldAddedColumns {
+        if migrator.HasColumn(&common.DatasetTableField{}, column) {
+            continue
+        }
+        err := migrator.AddColumn(&common.DatasetTableField{}, column)
+        if err != nil {
+            return errors.New(fmt.Sprintf("add column [%s] to dataset_table_field fail: %v", column, err))
+        }
+    }
+
+    return nil
+}
+
 func NewDatasets(db *gorm.DB, sources *datasource.Datasources) (*Datasets, error) {
+    err := datasetFieldMigrate(db)
+    if err != nil {
+        return nil, err
+    }
+
     ds := &Datasets{datasetMap: cmap.New(), sources: sources}
-    err := ds.datasetCacheInit(db)
+    err = ds.datasetCacheInit(db)
     if err != nil {
         return nil, err
     }
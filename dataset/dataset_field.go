@@ -1,7 +1,10 @@
 package dataset
 
 import (
+    "errors"
+    "fmt"
     "github.com/bingLAN/data_driver/common"
+    "github.com/bingLAN/data_driver/db_driver"
     "gorm.io/gorm"
 )
 
@@ -33,6 +36,74 @@ func createDatasetFields(datasetId string, fields []common.DatasetTableField) *D
     return &DatasetField{datasetId: datasetId, fields: fields}
 }
 
+// 将修改合并到字段列表的副本中，用于修改前的校验
+
+func fieldsMerge(fields []common.DatasetTableField, modify []common.DatasetTableField) []common.DatasetTableField {
+    modifyMap := make(map[string]*common.DatasetTableField)
+    for index, _ := range modify {
+        modifyMap[modify[index].FieldId] = &modify[index]
+    }
+
+    merged := make([]common.DatasetTableField, len(fields))
+    copy(merged, fields)
+    for index, _ := range merged {
+        if m, ok := modifyMap[merged[index].FieldId]; ok {
+            merged[index].Name = m.Name
+            merged[index].GroupType = m.GroupType
+            merged[index].Checked = m.Checked
+            merged[index].ColumnIndex = m.ColumnIndex
+            merged[index].AggFunc = m.AggFunc
+            merged[index].UniqueKey = m.UniqueKey
+            merged[index].DateFormat = m.DateFormat
+            merged[index].DateFormatType = m.DateFormatType
+            merged[index].TimeGranularity = m.TimeGranularity
+        }
+    }
+
+    return merged
+}
+
+// 校验字段：字段名不能为空、不能重复，也不能与其他字段的原始字段名相同(避免sql别名与列名互相遮蔽)
+// 计算字段的表达式需能在当前字段上通过检查
+
+func fieldsCheck(fields []common.DatasetTableField) error {
+    nameMap := make(map[string]string)
+    originMap := make(map[string]string)
+    for index, _ := range fields {
+        if fields[index].ExtField != 1 {
+            originMap[fields[index].OriginName] = fields[index].FieldId
+        }
+    }
+    for index, _ := range fields {
+        field := &fields[index]
+        if field.Name == "" {
+            return errors.New(fmt.Sprintf("field [%s] name is empty", field.OriginName))
+        }
+        if _, ok := nameMap[field.Name]; ok {
+            return errors.New(fmt.Sprintf("field name [%s] duplicate", field.Name))
+        }
+        if fieldId, ok := originMap[field.Name]; ok && fieldId != field.FieldId {
+            return errors.New(fmt.Sprintf("field name [%s] conflict with origin name of another field", field.Name))
+        }
+        nameMap[field.Name] = field.FieldId
+    }
+
+    for index, _ := range fields {
+        err := db_driver.TimeGranCheck(&fields[index], fields[index].TimeGranularity)
+        if err != nil {
+            return err
+        }
+        if fields[index].ExtField == 1 {
+            _, err := db_driver.ExprCheck(fields[index].OriginName, fields)
+            if err != nil {
+                return errors.New(fmt.Sprintf("calculated field [%s]: %v", fields[index].Name, err))
+            }
+        }
+    }
+
+    return nil
+}
+
 // 从数据库中找到对应dataset的field
 
 func getDatasetFields(datasetId string, db *gorm.DB) (*DatasetField, error) {
@@ -35,6 +35,13 @@ func (s *Datasource) CheckDatasource(db *gorm.DB) (db_driver.DBConnStatus, error
     return nowStatus, err
 }
 
+// 查看数据源驱动能力
+
+func (s *Datasource) Capability() db_driver.DBCapability {
+    driver, _ := db_driver.GetDriver(s.datasourceType)
+    return driver.Capability
+}
+
 type Datasources struct {
     dbDriverMap  cmap.ConcurrentMap     // id---*Datasource
 }
@@ -157,7 +164,7 @@ func (ds *Datasources) createDatasourceStruct(dt *common.DatasourceTable, db *go
     //}
 
 
-    if driver, ok := db_driver.DBDriverMap[dt.Type]; ok {
+    if driver, ok := db_driver.GetDriver(dt.Type); ok {
         var dbDriver db_driver.DBDriver
         dbDriver, err = driver.CreateFunc(*dt)
         if err == nil {
@@ -0,0 +1,142 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+)
+
+// 指标聚合方式对应的通用函数
+var aggFuncMap = map[string]string{
+    common.AggSum: FuncSum,
+    common.AggAvg: FuncAvg,
+    common.AggMin: FuncMin,
+    common.AggMax: FuncMax,
+    common.AggCount: FuncCount,
+    common.AggCountDistinct: FuncCountDistinct,
+}
+
+func isNumberType(dsType int64) bool {
+    return dsType == common.DSTypeInt || dsType == common.DSTypeDEC
+}
+
+// 确定指标字段的聚合方式，未配置时数值字段取sum，其他字段取count
+
+func quotaAggFunc(field *common.DatasetTableField) (string, error) {
+    aggFunc := field.AggFunc
+    if aggFunc == "" {
+        if isNumberType(field.DsType) {
+            return common.AggSum, nil
+        }
+        return common.AggCount, nil
+    }
+
+    if _, ok := aggFuncMap[aggFunc]; !ok {
+        return "", errors.New(fmt.Sprintf("field [%s] agg func [%s] not support", field.Name, aggFunc))
+    }
+    if (aggFunc == common.AggSum || aggFunc == common.AggAvg) && !isNumberType(field.DsType) {
+        return "", errors.New(fmt.Sprintf("field [%s] agg func [%s] need number field", field.Name, aggFunc))
+    }
+
+    return aggFunc, nil
+}
+
+// 聚合查询的组成部分，列名均已转义
+type aggregateParts struct {
+    project     string      // 投影列表
+    dims        []string    // 维度列，同时作为group by列表
+    quotas      []string    // 指标聚合表达式
+    quotaNames  []string    // 指标列
+    quotaFields []string    // 指标字段名
+}
+
+// 指标聚合结果以字段名为别名
+
+func (p *aggregateParts) quotaSelects() []string {
+    selects := make([]string, 0, len(p.quotas))
+    for index, _ := range p.quotas {
+        selects = append(selects, fmt.Sprintf("%s as %s", p.quotas[index], p.quotaNames[index]))
+    }
+
+    return selects
+}
+
+// 构建聚合查询的投影及聚合列
+// 只处理选中的字段，先按字段名投影(时间维度按粒度分桶)，再在投影结果上按维度分组、聚合指标
+
+func (s *SqlDriver) sqlAggregateBuild(fields []common.DatasetTableField, granMap map[string]string) (*aggregateParts, error) {
+    var projects []string
+    parts := &aggregateParts{}
+
+    for index, _ := range fields {
+        field := &fields[index]
+        if field.Checked != 1 {
+            continue
+        }
+        name := s.dialect.QuoteIdent(field.Name)
+        switch field.GroupType {
+        case common.FieldDimension:
+            if gran, ok := granMap[field.Name]; ok {
+                projects = append(projects, fmt.Sprintf("%s as %s", s.dialect.TimeBucket(s.sqlFieldColumn(field), gran), name))
+            } else {
+                projects = append(projects, s.sqlFieldSelect(field))
+            }
+            parts.dims = append(parts.dims, name)
+        case common.FieldQuota:
+            aggFunc, err := quotaAggFunc(field)
+            if err != nil {
+                return nil, err
+            }
+            projects = append(projects, s.sqlFieldSelect(field))
+            parts.quotas = append(parts.quotas, s.dialect.Func(aggFuncMap[aggFunc], name))
+            parts.quotaNames = append(parts.quotaNames, name)
+            parts.quotaFields = append(parts.quotaFields, field.Name)
+        }
+    }
+    if len(projects) == 0 {
+        return nil, errors.New("dataset has no dimension or quota field to aggregate")
+    }
+    parts.project = strings.Join(projects, ", ")
+
+    return parts, nil
+}
+
+// 构建聚合的数据来源：先过滤再投影，避免where中的字段被同名别名替换(如clickhouse)
+// shift不为空时过滤后的数据中的时间字段再按周期偏移，用于同环比
+// 返回投影查询以及聚合的组成部分
+
+func (s *SqlDriver) sqlAggregateSourceBuild(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery,
+    granMap map[string]string, shift *common.DsCompare) (*sqlQuery, *aggregateParts, error) {
+    from, err := s.sqlFromBuild(ctx, di, fields, query.Variables)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    // 过滤条件编译为绑定参数
+    where, whereArgs, err := s.sqlFilterBuild(query.Filter, fields)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    parts, err := s.sqlAggregateBuild(fields, granMap)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    source := from
+    if where != "" {
+        source = &sqlQuery{}
+        filtered := &sqlQuery{}
+        filtered.Write("select * from ").Append(from).Write(" where ").Write(where, whereArgs...)
+        source.Write(s.dialect.SubQuery(filtered.String()), filtered.Args()...)
+    }
+    if shift != nil {
+        source = s.sqlShiftFromBuild(source, fields, shift)
+    }
+    project := &sqlQuery{}
+    project.Write(fmt.Sprintf("select %s from ", parts.project)).Append(source)
+
+    return project, parts, nil
+}
@@ -0,0 +1,165 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+)
+
+// 对比周期的值在查询结果中的列名
+const compareColumnPrefix = "__compare_"
+
+// 校验同环比参数，对比字段需为按粒度分桶的时间维度，返回补全默认值的参数副本
+
+func compareCheck(query *common.DsQuery, fields []common.DatasetTableField, granMap map[string]string) (*common.DsCompare, error) {
+    compare := *query.Compare
+    fieldMap := fieldNameMapBuild(fields)
+    field, ok := fieldMap[compare.Field]
+    if !ok {
+        return nil, errors.New(fmt.Sprintf("compare field [%s] not define in dataset", compare.Field))
+    }
+    if _, ok := granMap[field.Name]; !ok {
+        return nil, errors.New(fmt.Sprintf("compare field [%s] need checked time dimension with granularity", compare.Field))
+    }
+    if _, ok := timeGranFormatMap[compare.Period]; !ok {
+        return nil, errors.New(fmt.Sprintf("compare period [%s] not support", compare.Period))
+    }
+    if compare.Periods == 0 {
+        compare.Periods = 1
+    }
+    if compare.Periods < 0 {
+        return nil, errors.New(fmt.Sprintf("compare periods [%d] need > 0", compare.Periods))
+    }
+
+    return &compare, nil
+}
+
+// 将时间字段向后偏移对比周期，偏移后上一周期的数据落在当前周期的分桶中
+// 在过滤后的数据上偏移，过滤条件作用于上一周期数据原始的时间
+
+func (s *SqlDriver) sqlShiftFromBuild(from *sqlQuery, fields []common.DatasetTableField, compare *common.DsCompare) *sqlQuery {
+    var columns []string
+    for index, _ := range fields {
+        field := &fields[index]
+        col := s.sqlFieldColumn(field)
+        if field.Name == compare.Field {
+            col = fmt.Sprintf("%s as %s", s.dialect.TimeShift(col, compare.Period, compare.Periods), col)
+        }
+        columns = append(columns, col)
+    }
+
+    shift := &sqlQuery{}
+    shift.Write(fmt.Sprintf("select %s from ", strings.Join(columns, ", "))).Append(from)
+    shifted := &sqlQuery{}
+    shifted.Write(s.dialect.SubQuery(shift.String()), shift.Args()...)
+
+    return shifted
+}
+
+// 同环比查询：当前周期与偏移后的上一周期分别聚合，再按维度(含偏移后的时间分桶)左关联
+// 只保留当前周期有数据的分组，维度为空值时同样能关联；上一周期的标记列用于区分未关联上的行(如clickhouse左关联默认不补空值)
+
+func (s *SqlDriver) sqlCompareBuild(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery,
+    granMap map[string]string) (*sqlQuery, error) {
+    compare, err := compareCheck(query, fields, granMap)
+    if err != nil {
+        return nil, err
+    }
+
+    flag := s.dialect.QuoteIdent(compareColumnPrefix + "flag")
+    periods := make([]*sqlQuery, 0, 2)
+    var parts *aggregateParts
+    for _, shift := range []*common.DsCompare{nil, compare} {
+        project, p, err := s.sqlAggregateSourceBuild(ctx, di, fields, query, granMap, shift)
+        if err != nil {
+            return nil, err
+        }
+        parts = p
+
+        // 指标以序号为别名，避免外层引用时与同名别名互相替换(如clickhouse)
+        selects := append([]string{}, parts.dims...)
+        for index, _ := range parts.quotas {
+            selects = append(selects, fmt.Sprintf("%s as %s", parts.quotas[index], s.dialect.QuoteIdent(fmt.Sprintf("%sq%d", compareColumnPrefix, index))))
+        }
+        if shift != nil {
+            selects = append(selects, fmt.Sprintf("1 as %s", flag))
+        }
+
+        period := &sqlQuery{}
+        period.Write(fmt.Sprintf("select %s from ", strings.Join(selects, ", "))).Write(s.dialect.SubQuery(project.String()), project.Args()...)
+        period.Write(fmt.Sprintf(" group by %s", strings.Join(parts.dims, ", ")))
+        periods = append(periods, period)
+    }
+
+    cur := s.dialect.QuoteIdent(compareColumnPrefix + "cur")
+    prev := s.dialect.QuoteIdent(compareColumnPrefix + "prev")
+    var selects, conds []string
+    for _, dim := range parts.dims {
+        selects = append(selects, fmt.Sprintf("%s.%s as %s", cur, dim, dim))
+        conds = append(conds, fmt.Sprintf("(%s.%s = %s.%s or (%s.%s is null and %s.%s is null))", cur, dim, prev, dim, cur, dim, prev, dim))
+    }
+    for index, _ := range parts.quotas {
+        q := s.dialect.QuoteIdent(fmt.Sprintf("%sq%d", compareColumnPrefix, index))
+        selects = append(selects, fmt.Sprintf("%s.%s as %s", cur, q, parts.quotaNames[index]))
+        selects = append(selects, fmt.Sprintf("(case when %s.%s = 1 then %s.%s end) as %s", prev, flag, prev, q,
+            s.dialect.QuoteIdent(compareColumnPrefix + parts.quotaFields[index])))
+    }
+
+    join := &sqlQuery{}
+    join.Write(fmt.Sprintf("select %s from (", strings.Join(selects, ", "))).Append(periods[0]).Write(fmt.Sprintf(") %s left join (", cur))
+    join.Append(periods[1]).Write(fmt.Sprintf(") %s on %s", prev, strings.Join(conds, " and ")))
+
+    // 外层再包一层，排序、分页只引用关联结果的列
+    sql := &sqlQuery{}
+    sql.Write("select * from ").Write(s.dialect.SubQuery(join.String()), join.Args()...)
+
+    return sql, nil
+}
+
+// 取出各行对比周期的指标值，需在去掉未选中的列之前调用
+
+func compareValuesTake(rows []common.SqlRes) []map[string]interface{} {
+    values := make([]map[string]interface{}, 0, len(rows))
+    for index, _ := range rows {
+        value := make(map[string]interface{})
+        for k, v := range rows[index] {
+            if strings.HasPrefix(k, compareColumnPrefix) {
+                value[strings.TrimPrefix(k, compareColumnPrefix)] = v
+            }
+        }
+        values = append(values, value)
+    }
+
+    return values
+}
+
+// 填充指标的对比值、变化量以及变化率，整数指标的变化量保持整数
+
+func compareFill(dsRes *common.DsResult, values []map[string]interface{}) {
+    for seriesIndex, _ := range dsRes.Series {
+        series := &dsRes.Series[seriesIndex]
+        for index, _ := range series.Data {
+            data := &series.Data[index]
+            prev := values[index][series.Name]
+            data.CompareValue = prev
+
+            cur, curOk := resultNumber(data.Value)
+            last, lastOk := resultNumber(prev)
+            if !curOk || !lastOk {
+                continue
+            }
+            curInt, curIsInt := data.Value.(int64)
+            lastInt, lastIsInt := prev.(int64)
+            if curIsInt && lastIsInt {
+                data.Change = curInt - lastInt
+            } else {
+                data.Change = cur - last
+            }
+            if last != 0 {
+                data.ChangeRate = (cur - last) / last
+            }
+        }
+    }
+}
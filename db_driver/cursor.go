@@ -0,0 +1,215 @@
+package db_driver
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+    "time"
+)
+
+// 游标内容：排序键(字段名:方向)以及上一页最后一行的排序键值
+
+type dsCursor struct {
+    Keys    []string        `json:"k"`
+    Values  []interface{}   `json:"v"`
+}
+
+// 数据集声明的唯一键，多个时取第一个
+
+func uniqueKeyField(fields []common.DatasetTableField) *common.DatasetTableField {
+    for index, _ := range fields {
+        if fields[index].UniqueKey == 1 {
+            return &fields[index]
+        }
+    }
+
+    return nil
+}
+
+// 构建分页使用的排序键，数据集声明了唯一键时在排序键之后追加唯一键保证顺序稳定
+// 返回是否支持游标分页：需要唯一键且非聚合查询
+// 游标分页时未指定空值位置的排序键按空值最小处理(asc在前、desc在后)，使各数据库顺序一致
+
+func pageSortsBuild(query *common.DsQuery, fields []common.DatasetTableField) ([]common.DsSort, bool, error) {
+    sorts, err := sortCheck(query.Sorts, fields)
+    if err != nil {
+        return nil, false, err
+    }
+
+    key := uniqueKeyField(fields)
+    if query.Aggregate || key == nil {
+        if query.Cursor != "" {
+            return nil, false, errors.New("cursor paging need a unique key field and non aggregate query")
+        }
+        return sorts, false, nil
+    }
+
+    keyFound := false
+    for _, sort := range sorts {
+        if sort.Field == key.Name {
+            keyFound = true
+        }
+    }
+    if !keyFound {
+        sorts = append(sorts, common.DsSort{Field: key.Name, Order: common.SortAsc})
+    }
+    for index, _ := range sorts {
+        if sorts[index].Nulls != "" {
+            continue
+        }
+        sorts[index].Nulls = common.SortNullsFirst
+        if sorts[index].Order == common.SortDesc {
+            sorts[index].Nulls = common.SortNullsLast
+        }
+    }
+
+    return sorts, true, nil
+}
+
+func cursorKeys(sorts []common.DsSort) []string {
+    var keys []string
+    for _, sort := range sorts {
+        keys = append(keys, sort.Field + ":" + sort.Order + ":" + sort.Nulls)
+    }
+
+    return keys
+}
+
+// 游标中的时间保留到纳秒，同一秒内的行也能区分先后
+const cursorTimeFormat = "2006-01-02 15:04:05.999999999"
+
+// 排序键值转换为绑定值，空值原样保留
+
+func cursorValueConvert(value interface{}, field *common.DatasetTableField) (interface{}, error) {
+    if value == nil {
+        return nil, nil
+    }
+    if field.DsType == common.DSTypeTime {
+        switch v := value.(type) {
+        case time.Time:
+            return v.Format(cursorTimeFormat), nil
+        case string:
+            if t, ok := parseTimeText(strings.TrimSpace(v)); ok {
+                return t.Format(cursorTimeFormat), nil
+            }
+        }
+        return nil, errors.New(fmt.Sprintf("sort field [%s] value [%v] is not time", field.Name, value))
+    }
+
+    return filterValueConvert(value, field)
+}
+
+// 根据最后一行生成游标
+
+func cursorEncode(sorts []common.DsSort, row common.SqlRes, fieldMap map[string]*common.DatasetTableField) (string, error) {
+    cursor := dsCursor{Keys: cursorKeys(sorts)}
+    for _, sort := range sorts {
+        value, err := cursorValueConvert(row[sort.Field], fieldMap[sort.Field])
+        if err != nil {
+            return "", err
+        }
+        cursor.Values = append(cursor.Values, value)
+    }
+
+    data, err := json.Marshal(cursor)
+    if err != nil {
+        return "", err
+    }
+
+    return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// 解析游标，排序键需与本次查询一致，返回转换为绑定值的排序键值
+
+func cursorDecode(text string, sorts []common.DsSort, fieldMap map[string]*common.DatasetTableField) ([]interface{}, error) {
+    data, err := base64.RawURLEncoding.DecodeString(text)
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("cursor [%s] invalid", text))
+    }
+
+    var cursor dsCursor
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    err = decoder.Decode(&cursor)
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("cursor [%s] invalid", text))
+    }
+
+    keys := cursorKeys(sorts)
+    if strings.Join(cursor.Keys, ",") != strings.Join(keys, ",") || len(cursor.Values) != len(keys) {
+        return nil, errors.New(fmt.Sprintf("cursor sort keys %v not match query sort keys %v", cursor.Keys, keys))
+    }
+
+    var values []interface{}
+    for index, sort := range sorts {
+        value, err := cursorValueConvert(cursor.Values[index], fieldMap[sort.Field])
+        if err != nil {
+            return nil, err
+        }
+        values = append(values, value)
+    }
+
+    return values, nil
+}
+
+// 排序键等于游标值的条件
+
+func keysetEqualWrite(where *sqlQuery, col string, value interface{}) {
+    if value == nil {
+        where.Write(col + " is null")
+        return
+    }
+
+    where.Write(col + " = ?", value)
+}
+
+// 排序键排在游标值之后的条件，排序键的空值位置已明确给出
+
+func keysetAfterWrite(where *sqlQuery, col string, sort common.DsSort, value interface{}) {
+    if value == nil {
+        // 空值在前时其后为所有非空值，空值在后时其后没有数据
+        if sort.Nulls == common.SortNullsFirst {
+            where.Write(col + " is not null")
+        } else {
+            where.Write("1 = 0")
+        }
+        return
+    }
+
+    op := ">"
+    if sort.Order == common.SortDesc {
+        op = "<"
+    }
+    if sort.Nulls == common.SortNullsLast {
+        where.Write(fmt.Sprintf("(%s %s ? or %s is null)", col, op, col), value)
+        return
+    }
+    where.Write(fmt.Sprintf("%s %s ?", col, op), value)
+}
+
+// 构建游标之后的行条件：(a > ?) or (a = ? and b < ?) or ...，方向以及空值位置决定比较方式
+
+func keysetWhereBuild(sorts []common.DsSort, values []interface{}, fieldMap map[string]*common.DatasetTableField,
+    column func(field *common.DatasetTableField) string) *sqlQuery {
+    where := &sqlQuery{}
+    where.Write("(")
+    for index, sort := range sorts {
+        if index > 0 {
+            where.Write(" or ")
+        }
+        where.Write("(")
+        for prev := 0; prev < index; prev++ {
+            keysetEqualWrite(where, column(fieldMap[sorts[prev].Field]), values[prev])
+            where.Write(" and ")
+        }
+        keysetAfterWrite(where, column(fieldMap[sort.Field]), sort, values[index])
+        where.Write(")")
+    }
+    where.Write(")")
+
+    return where
+}
@@ -0,0 +1,130 @@
+package db_driver
+
+import (
+    "encoding/base64"
+    "github.com/bingLAN/data_driver/common"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// 游标分页的排序键追加唯一键，未指定空值位置时按空值最小处理
+
+func TestPageSortsBuild(t *testing.T) {
+    fields := testFieldsBuild()
+    cases := []struct {
+        name    string
+        query   *common.DsQuery
+        want    []common.DsSort
+        keyset  bool
+    }{
+        {"append key", &common.DsQuery{Sorts: []common.DsSort{{Field: "rate", Order: "DESC"}}},
+            []common.DsSort{
+                {Field: "rate", Order: common.SortDesc, Nulls: common.SortNullsLast},
+                {Field: "ul_bytes", Order: common.SortAsc, Nulls: common.SortNullsFirst},
+            }, true},
+        {"sort by key", &common.DsQuery{Sorts: []common.DsSort{{Field: "ul_bytes", Order: common.SortDesc, Nulls: common.SortNullsFirst}}},
+            []common.DsSort{{Field: "ul_bytes", Order: common.SortDesc, Nulls: common.SortNullsFirst}}, true},
+        {"aggregate", &common.DsQuery{Sorts: []common.DsSort{{Field: "rate"}}, Aggregate: true},
+            []common.DsSort{{Field: "rate", Order: common.SortAsc}}, false},
+    }
+    for _, c := range cases {
+        sorts, keyset, err := pageSortsBuild(c.query, fields)
+        if err != nil {
+            t.Errorf("%s: %v", c.name, err)
+            continue
+        }
+        if !reflect.DeepEqual(sorts, c.want) || keyset != c.keyset {
+            t.Errorf("%s: sorts %+v keyset %v, want %+v %v", c.name, sorts, keyset, c.want, c.keyset)
+        }
+    }
+
+    // 聚合查询以及没有唯一键时不能使用游标
+    _, _, err := pageSortsBuild(&common.DsQuery{Cursor: "x", Aggregate: true}, fields)
+    if err == nil {
+        t.Errorf("aggregate cursor should fail")
+    }
+    _, _, err = pageSortsBuild(&common.DsQuery{Cursor: "x"}, fields[:2])
+    if err == nil {
+        t.Errorf("cursor without unique key should fail")
+    }
+}
+
+// 游标保存排序键以及末行的排序键值，解码时排序键需一致
+
+func TestCursorEncode(t *testing.T) {
+    fields := testFieldsBuild()
+    fieldMap := fieldNameMapBuild(fields)
+    sorts := []common.DsSort{
+        {Field: "ts", Order: common.SortDesc, Nulls: common.SortNullsLast},
+        {Field: "province", Order: common.SortAsc, Nulls: common.SortNullsFirst},
+        {Field: "ul_bytes", Order: common.SortAsc, Nulls: common.SortNullsFirst},
+    }
+    row := common.SqlRes{
+        "ts": time.Date(2023, 5, 1, 10, 0, 0, 300000000, time.Local),
+        "province": nil,
+        "ul_bytes": int64(9007199254740993),
+    }
+    cursor, err := cursorEncode(sorts, row, fieldMap)
+    if err != nil {
+        t.Fatal(err)
+    }
+    values, err := cursorDecode(cursor, sorts, fieldMap)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []interface{}{"2023-05-01 10:00:00.3", nil, int64(9007199254740993)}
+    if !reflect.DeepEqual(values, want) {
+        t.Errorf("cursor values %v, want %v", values, want)
+    }
+
+    // 内容非法、排序方向或空值位置与查询不一致、排序键值类型不匹配
+    invalid := []struct {
+        cursor  string
+        sorts   []common.DsSort
+    }{
+        {"not a cursor", sorts},
+        {base64.RawURLEncoding.EncodeToString([]byte("[1]")), sorts},
+        {cursor, []common.DsSort{sorts[0], sorts[1]}},
+        {cursor, []common.DsSort{sorts[0], sorts[1], {Field: "ul_bytes", Order: common.SortDesc, Nulls: common.SortNullsFirst}}},
+        {base64.RawURLEncoding.EncodeToString([]byte(`{"k":["ul_bytes:asc:first"],"v":["abc"]}`)), sorts[2:]},
+    }
+    for _, c := range invalid {
+        _, err = cursorDecode(c.cursor, c.sorts, fieldMap)
+        if err == nil {
+            t.Errorf("cursor [%s] sorts %+v should fail", c.cursor, c.sorts)
+        }
+    }
+}
+
+// 游标之后的行条件，空值按排序中的位置比较
+
+func TestKeysetWhereBuild(t *testing.T) {
+    fieldMap := fieldNameMapBuild(testFieldsBuild())
+    column := func(field *common.DatasetTableField) string {
+        return field.OriginName
+    }
+    key := common.DsSort{Field: "ul_bytes", Order: common.SortAsc, Nulls: common.SortNullsFirst}
+    cases := []struct {
+        name    string
+        sort    common.DsSort
+        value   interface{}
+        want    string
+        args    []interface{}
+    }{
+        {"asc", common.DsSort{Field: "rate", Order: common.SortAsc, Nulls: common.SortNullsFirst}, 3.5,
+            "((rate > ?) or (rate = ? and ul_bytes > ?))", []interface{}{3.5, 3.5, int64(7)}},
+        {"desc nulls last", common.DsSort{Field: "rate", Order: common.SortDesc, Nulls: common.SortNullsLast}, 3.5,
+            "(((rate < ? or rate is null)) or (rate = ? and ul_bytes > ?))", []interface{}{3.5, 3.5, int64(7)}},
+        {"null first", common.DsSort{Field: "rate", Order: common.SortAsc, Nulls: common.SortNullsFirst}, nil,
+            "((rate is not null) or (rate is null and ul_bytes > ?))", []interface{}{int64(7)}},
+        {"null last", common.DsSort{Field: "rate", Order: common.SortDesc, Nulls: common.SortNullsLast}, nil,
+            "((1 = 0) or (rate is null and ul_bytes > ?))", []interface{}{int64(7)}},
+    }
+    for _, c := range cases {
+        where := keysetWhereBuild([]common.DsSort{c.sort, key}, []interface{}{c.value, int64(7)}, fieldMap, column)
+        if where.String() != c.want || !reflect.DeepEqual(where.Args(), c.args) {
+            t.Errorf("%s: [%s] %v, want [%s] %v", c.name, where.String(), where.Args(), c.want, c.args)
+        }
+    }
+}
@@ -1,7 +1,13 @@
 package db_driver
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "github.com/bingLAN/data_driver/common"
+    "sort"
+    "sync"
+    "time"
 )
 
 
@@ -29,15 +35,127 @@ func string2DBConnStatus(s string) DBConnStatus {
 const (
     DatasourceCH string = "clickhouse"
     DatasourceMYSQL string = "mysql"
+    DatasourcePG string = "postgres"
+    DatasourceSQLITE string = "sqlite"
+    DatasourceFile string = "file"
+    DatasourceHTTP string = "http"
 )
 
+// DBCapability 驱动能力描述，调用方可在构建查询前确认数据源支持的特性
+
+type DBCapability struct {
+    Sampling        bool    `json:"sampling"`       // 支持采样查询
+    WindowFunc      bool    `json:"windowFunc"`     // 支持窗口函数
+    Aggregation     bool    `json:"aggregation"`    // 支持服务端聚合
+    Streaming       bool    `json:"streaming"`      // 支持流式读取结果
+}
+
 type DBDriverHandle struct {
     CreateFunc  func(datasourceInfo common.DatasourceTable) (DBDriver, error)
+    Capability  DBCapability
+}
+
+var dbDriverLock sync.RWMutex
+
+var dbDriverMap = map[string] DBDriverHandle {
+    DatasourceCH: {
+        CreateFunc: NewClickhouseDriver,
+        Capability: DBCapability{Sampling: true, WindowFunc: true, Aggregation: true, Streaming: true},
+    },
+    DatasourceMYSQL: {
+        CreateFunc: NewMysqlDriver,
+        Capability: DBCapability{WindowFunc: true, Aggregation: true, Streaming: true},
+    },
+    DatasourcePG: {
+        CreateFunc: NewPostgresDriver,
+        Capability: DBCapability{Sampling: true, WindowFunc: true, Aggregation: true, Streaming: true},
+    },
+    DatasourceSQLITE: {
+        CreateFunc: NewSqliteDriver,
+        Capability: DBCapability{WindowFunc: true, Aggregation: true, Streaming: true},
+    },
+    DatasourceFile: {
+        CreateFunc: NewFileDriver,
+        Capability: DBCapability{WindowFunc: true, Aggregation: true},
+    },
+    DatasourceHTTP: {
+        CreateFunc: NewHttpDriver,
+        Capability: DBCapability{},
+    },
+}
+
+// DBDriverMap 旧版驱动表，初始为内置驱动的副本，与RegisterDriver的注册表相互独立
+// 通过该map添加的驱动仍可由GetDriver、DriverNames查到，RegisterDriver注册的驱动不会出现在该map中
+//
+// Deprecated: 直接读写该map不是并发安全的，请使用RegisterDriver、GetDriver以及DriverNames
+
+var DBDriverMap = dbDriverMapCopy()
+
+func dbDriverMapCopy() map[string] DBDriverHandle {
+    driverMap := make(map[string] DBDriverHandle, len(dbDriverMap))
+    for name, handle := range dbDriverMap {
+        driverMap[name] = handle
+    }
+
+    return driverMap
+}
+
+// RegisterDriver 注册数据源驱动，供外部模块接入自定义后端
+// 并发安全，name已存在时返回错误
+
+func RegisterDriver(name string, handle DBDriverHandle) error {
+    if name == "" {
+        return errors.New("driver name is empty")
+    }
+    if handle.CreateFunc == nil {
+        return errors.New(fmt.Sprintf("driver [%s] CreateFunc is nil", name))
+    }
+
+    dbDriverLock.Lock()
+    defer dbDriverLock.Unlock()
+
+    if _, ok := dbDriverMap[name]; ok {
+        return errors.New(fmt.Sprintf("driver [%s] already registered", name))
+    }
+    if _, ok := DBDriverMap[name]; ok {
+        return errors.New(fmt.Sprintf("driver [%s] already registered", name))
+    }
+    dbDriverMap[name] = handle
+
+    return nil
 }
 
-var DBDriverMap = map[string] DBDriverHandle {
-    DatasourceCH: {CreateFunc: NewClickhouseDriver},
-    DatasourceMYSQL: {CreateFunc: NewMysqlDriver},
+// GetDriver 根据数据源类型查找驱动，未注册时再查找旧版DBDriverMap
+
+func GetDriver(name string) (DBDriverHandle, bool) {
+    dbDriverLock.RLock()
+    defer dbDriverLock.RUnlock()
+
+    if handle, ok := dbDriverMap[name]; ok {
+        return handle, true
+    }
+    handle, ok := DBDriverMap[name]
+    return handle, ok
+}
+
+// DriverNames 查看已注册的所有驱动类型，包含旧版DBDriverMap中的驱动
+
+func DriverNames() []string {
+    dbDriverLock.RLock()
+    defer dbDriverLock.RUnlock()
+
+    var names []string
+    for name, _ := range dbDriverMap {
+        names = append(names, name)
+    }
+    for name, _ := range DBDriverMap {
+        if _, ok := dbDriverMap[name]; !ok {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+
+    return names
 }
 
 type DBDriver interface {
@@ -45,14 +163,29 @@ type DBDriver interface {
     Close() error           // 关闭
     GetDBConnStatus() DBConnStatus      // 查看数据记录的连接状态
     CheckDBConnStatus() DBConnStatus    // 调用api查看当前连接状态
-    GetDataFields(dsTable common.DatasetTable) ([]common.DatasetTableField, error)  // 获取该数据集所有field域信息
-    GetData(datasetId string, di *common.DatasetTable, fields []common.DatasetTableField, offset, limit int, sortNames []string, sortOpt string, filter string) (*common.DsResult, error) // 数据访问
+    // 以下接口在ctx取消或超过数据源QueryTimeout时中止查询
+    GetDataFields(ctx context.Context, dsTable common.DatasetTable) ([]common.DatasetTableField, error)  // 获取该数据集所有field域信息
+    GetData(ctx context.Context, datasetId string, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery) (*common.DsResult, error) // 数据访问
+    GetFieldValues(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, fieldName string, search string, limit int,
+        options *common.DsValueOptions) ([]common.DsFieldValue, error)     // 字段的去重取值及出现次数
+    GetFieldProfiles(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField) (map[string]common.FieldProfile, error) // 各字段统计信息，key为FieldId
+}
+
+// 查询上下文加上数据源的查询超时，QueryTimeout为0时不限制
+
+func queryContext(ctx context.Context, config common.Configuration) (context.Context, context.CancelFunc) {
+    if config.QueryTimeout == 0 {
+        return context.WithCancel(ctx)
+    }
+
+    return context.WithTimeout(ctx, time.Duration(config.QueryTimeout) * time.Second)
 }
 
 type FieldDef struct {
     Name string             //  字段名名
     GroupType string        //  维度/指标标识 d:维度，q:指标
     ColumnIndex int64       //  列位置
+    TimeFormat string       //  时间字段的标签格式，非时间字段为空
 }
 
 type FieldDefList []FieldDef
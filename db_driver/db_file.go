@@ -0,0 +1,606 @@
+package db_driver
+
+import (
+    "context"
+    "crypto/md5"
+    "encoding/csv"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "github.com/xuri/excelize/v2"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// FileDriver 文件数据源，数据集类型为excel，Info为csv/xlsx文件路径
+// Config.DataBase为文件根目录(必填)，相对路径基于该目录解析，绝对路径也需位于该目录下
+// 文件内容导入内存sqlite库后查询，offset/limit/sort/filter与sql驱动语义一致
+
+type FileDriver struct {
+    datasourceInfo      common.DatasourceTable
+    memDriver           *SqlDriver
+    tableLock           sync.RWMutex    // 查询持有读锁，导入、重建内存表以及关闭持有写锁
+    tableMap            map[string]*fileTable      // 文件路径---内存表
+}
+
+const fileLoadRetry = 3         // 导入过程中内存库被重建时的重试次数
+
+type fileColumn struct {
+    name        string
+    dsType      int64
+}
+
+type fileTable struct {
+    name        string      // 内存库中的表名
+    modTime     time.Time   // 导入时文件的修改时间，文件变化后重新导入
+    columns     []fileColumn
+}
+
+// DBConn 创建内存库
+
+func (f *FileDriver) DBConn() error {
+    // 检查文件根目录，数据集只能访问根目录下的文件，因此根目录必须给出
+    if f.datasourceInfo.Config.DataBase == "" {
+        f.datasourceInfo.Status = ConnFail
+        return errors.New(fmt.Sprintf("file datasource [%s] directory is empty", f.datasourceInfo.Name))
+    }
+    info, err := os.Stat(f.datasourceInfo.Config.DataBase)
+    if err != nil {
+        f.datasourceInfo.Status = ConnFail
+        return err
+    }
+    if !info.IsDir() {
+        f.datasourceInfo.Status = ConnFail
+        return errors.New(fmt.Sprintf("file datasource path [%s] is not a directory", f.datasourceInfo.Config.DataBase))
+    }
+
+    // 内存库在最后一个连接关闭后销毁，因此固定一个连接且不做空闲回收
+    memInfo := common.DatasourceTable{
+        DatasourceId: f.datasourceInfo.DatasourceId,
+        Name: f.datasourceInfo.Name,
+        Type: DatasourceSQLITE,
+        Config: common.Configuration{
+            DataBase: fmt.Sprintf("file:%s", common.GetUUID()),
+            ExtraParams: "mode=memory&cache=shared",
+            MaxPoolSize: 1,
+            MaxIdleTime: 1,
+            QueryTimeout: f.datasourceInfo.Config.QueryTimeout,
+        },
+    }
+    memDriver := &SqlDriver{datasourceInfo: memInfo, dialect: SqliteDialect{}}
+    err = memDriver.DBConn()
+    if err != nil {
+        f.datasourceInfo.Status = ConnFail
+        return err
+    }
+
+    f.tableLock.Lock()
+    f.memDriver = memDriver
+    f.tableMap = make(map[string]*fileTable)
+    f.tableLock.Unlock()
+    f.datasourceInfo.Status = ConnSuccess
+
+    return nil
+}
+
+// 解析文件路径，相对路径基于根目录且不允许越出根目录
+
+func (f *FileDriver) filePath(info string) (string, string, error) {
+    path := strings.TrimSpace(info)
+    sheet := ""
+    // xlsx可以通过 path#sheet 指定工作表
+    if index := strings.LastIndex(path, "#"); index >= 0 {
+        path, sheet = path[:index], path[index+1:]
+    }
+    if path == "" {
+        return "", "", errors.New("file dataset info is empty")
+    }
+
+    baseDir := f.datasourceInfo.Config.DataBase
+    if baseDir == "" {
+        return "", "", errors.New(fmt.Sprintf("file datasource [%s] directory is empty", f.datasourceInfo.Name))
+    }
+
+    if !filepath.IsAbs(path) {
+        path = filepath.Join(baseDir, path)
+    }
+    rel, err := filepath.Rel(baseDir, path)
+    if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+        return "", "", errors.New(fmt.Sprintf("file [%s] is outside of datasource directory", info))
+    }
+
+    return filepath.Clean(path), sheet, nil
+}
+
+func readCSVRecords(path string) ([][]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    reader := csv.NewReader(file)
+    if strings.ToLower(filepath.Ext(path)) == ".tsv" {
+        reader.Comma = '\t'
+    }
+    reader.FieldsPerRecord = -1
+    reader.LazyQuotes = true
+
+    var records [][]string
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+        records = append(records, record)
+    }
+
+    // 去除utf8 bom
+    if len(records) > 0 && len(records[0]) > 0 {
+        records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+    }
+
+    return records, nil
+}
+
+func readXLSXRecords(path string, sheet string) ([][]string, error) {
+    file, err := excelize.OpenFile(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    if sheet == "" {
+        // 默认第一个工作表
+        sheet = file.GetSheetName(0)
+    }
+
+    rows, err := file.GetRows(sheet)
+    if err != nil {
+        return nil, err
+    }
+    rawRows, err := file.GetRows(sheet, excelize.Options{RawCellValue: true})
+    if err != nil {
+        return nil, err
+    }
+    date1904 := false
+    if props, err := file.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+        date1904 = *props.Date1904
+    }
+
+    for index, _ := range rows {
+        if index >= len(rawRows) {
+            break
+        }
+        for col, _ := range rows[index] {
+            if col < len(rawRows[index]) {
+                rows[index][col] = xlsxDateText(rows[index][col], rawRows[index][col], date1904)
+            }
+        }
+    }
+
+    return rows, nil
+}
+
+// excel内置的日期格式为两位年份(如mm-dd-yy)，文本无法可靠识别为时间
+// 这里按单元格的原始序列值转换为标准时间文本，其他单元格原样返回
+
+var xlsxDateLayouts = []string{"01-02-06", "1/2/06 15:04"}
+
+func xlsxDateText(value string, raw string, date1904 bool) string {
+    if value == raw {
+        return value
+    }
+    serial, err := strconv.ParseFloat(raw, 64)
+    if err != nil {
+        return value
+    }
+    for _, layout := range xlsxDateLayouts {
+        if _, err := time.Parse(layout, value); err != nil {
+            continue
+        }
+        t, err := excelize.ExcelDateToTime(serial, date1904)
+        if err != nil {
+            return value
+        }
+        return t.Round(time.Second).Format(timeTextFormat)
+    }
+
+    return value
+}
+
+func readFileRecords(path string, sheet string) ([][]string, error) {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".csv", ".tsv", ".txt":
+        return readCSVRecords(path)
+    case ".xlsx", ".xlsm":
+        return readXLSXRecords(path, sheet)
+    default:
+        return nil, errors.New(fmt.Sprintf("file [%s] type not support", path))
+    }
+}
+
+// 首行为表头，空表头或重复表头自动重命名
+
+func fileHeader(header []string, width int) []string {
+    names := make([]string, width)
+    used := make(map[string]struct{})
+    for index := 0; index < width; index++ {
+        name := ""
+        if index < len(header) {
+            name = strings.TrimSpace(header[index])
+        }
+        if name == "" {
+            name = fmt.Sprintf("column_%d", index + 1)
+        }
+        base := name
+        for seq := 2; ; seq++ {
+            if _, ok := used[name]; !ok {
+                break
+            }
+            name = fmt.Sprintf("%s_%d", base, seq)
+        }
+        used[name] = struct{}{}
+        names[index] = name
+    }
+
+    return names
+}
+
+// 根据列的全部非空值推断类型：整形 > 浮点 > 时间 > 文本
+
+func inferFileColumnType(rows [][]string, index int) int64 {
+    isInt, isDec, isTime := true, true, true
+    hasValue := false
+    for _, row := range rows {
+        if index >= len(row) {
+            continue
+        }
+        value := strings.TrimSpace(row[index])
+        if value == "" {
+            continue
+        }
+        hasValue = true
+        if isInt {
+            if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+                isInt = false
+            }
+        }
+        if isDec {
+            if _, err := strconv.ParseFloat(value, 64); err != nil {
+                isDec = false
+            }
+        }
+        if isTime {
+            if _, ok := parseTimeText(value); !ok {
+                isTime = false
+            }
+        }
+        if !isInt && !isDec && !isTime {
+            break
+        }
+    }
+
+    switch {
+    case !hasValue:
+        return common.DSTypeVar
+    case isInt:
+        return common.DSTypeInt
+    case isDec:
+        return common.DSTypeDEC
+    case isTime:
+        return common.DSTypeTime
+    default:
+        return common.DSTypeVar
+    }
+}
+
+func fileColumnDeclType(dsType int64) string {
+    switch dsType {
+    case common.DSTypeInt:
+        return "INTEGER"
+    case common.DSTypeDEC:
+        return "REAL"
+    case common.DSTypeTime:
+        return "DATETIME"
+    default:
+        return "TEXT"
+    }
+}
+
+func fileCellValue(value string, dsType int64) interface{} {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return nil
+    }
+
+    switch dsType {
+    case common.DSTypeInt:
+        v, _ := strconv.ParseInt(value, 10, 64)
+        return v
+    case common.DSTypeDEC:
+        v, _ := strconv.ParseFloat(value, 64)
+        return v
+    case common.DSTypeTime:
+        t, _ := parseTimeText(value)
+        return t.Format(timeTextFormat)
+    default:
+        return value
+    }
+}
+
+// 将文件导入内存库，文件未变化时直接复用，返回内存表以及其在tableMap中的key
+
+func (f *FileDriver) loadTable(info string) (*fileTable, string, error) {
+    path, sheet, err := f.filePath(info)
+    if err != nil {
+        return nil, "", err
+    }
+    stat, err := os.Stat(path)
+    if err != nil {
+        return nil, "", err
+    }
+
+    f.tableLock.Lock()
+    defer f.tableLock.Unlock()
+
+    if f.memDriver == nil || f.memDriver.dbConn == nil {
+        return nil, "", errors.New(fmt.Sprintf("file datasource [%s] not available", f.datasourceInfo.Name))
+    }
+
+    key := path + "#" + sheet
+    if table, ok := f.tableMap[key]; ok && table.modTime.Equal(stat.ModTime()) {
+        return table, key, nil
+    }
+
+    records, err := readFileRecords(path, sheet)
+    if err != nil {
+        return nil, "", err
+    }
+    if len(records) == 0 {
+        return nil, "", errors.New(fmt.Sprintf("file [%s] is empty", info))
+    }
+
+    // 计算列数
+    width := 0
+    for _, record := range records {
+        if len(record) > width {
+            width = len(record)
+        }
+    }
+    header := fileHeader(records[0], width)
+    rows := records[1:]
+
+    sum := md5.Sum([]byte(key))
+    table := &fileTable{
+        name: "file_" + hex.EncodeToString(sum[:]),
+        modTime: stat.ModTime(),
+    }
+    var colDefs []string
+    for index, name := range header {
+        dsType := inferFileColumnType(rows, index)
+        table.columns = append(table.columns, fileColumn{name: name, dsType: dsType})
+        colDefs = append(colDefs, fmt.Sprintf("%s %s", f.memDriver.dialect.QuoteIdent(name), fileColumnDeclType(dsType)))
+    }
+
+    // 重建内存表
+    tx := f.memDriver.dbConn.Begin()
+    err = tx.Exec(fmt.Sprintf("drop table if exists %s", table.name)).Error
+    if err == nil {
+        err = tx.Exec(fmt.Sprintf("create table %s (%s)", table.name, strings.Join(colDefs, ", "))).Error
+    }
+    if err != nil {
+        tx.Rollback()
+        return nil, "", err
+    }
+
+    insertSql := fmt.Sprintf("insert into %s values (%s)", table.name, strings.TrimSuffix(strings.Repeat("?, ", width), ", "))
+    for _, row := range rows {
+        args := make([]interface{}, width)
+        for index, column := range table.columns {
+            if index < len(row) {
+                args[index] = fileCellValue(row[index], column.dsType)
+            }
+        }
+        err = tx.Exec(insertSql, args...).Error
+        if err != nil {
+            tx.Rollback()
+            return nil, "", err
+        }
+    }
+    err = tx.Commit().Error
+    if err != nil {
+        return nil, "", err
+    }
+
+    f.tableMap[key] = table
+
+    return table, key, nil
+}
+
+// 根据数据集信息获取所有field
+
+func (f *FileDriver) GetDataFields(ctx context.Context, dsTable common.DatasetTable) ([]common.DatasetTableField, error) {
+    if dsTable.Type != common.DatasetTypeExcel {
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not support", dsTable.Type))
+    }
+
+    table, _, err := f.loadTable(dsTable.Info)
+    if err != nil {
+        return nil, err
+    }
+
+    var datasetFields []common.DatasetTableField
+    for index, column := range table.columns {
+        datasetFields = append(datasetFields,
+            common.DatasetTableField{
+                FieldId: getDatasetFieldId(),
+                DatasetId: dsTable.DatasetId,
+                OriginName: column.name,
+                Name: column.name,
+                GroupType: common.FieldDimension,
+                Type: fileColumnDeclType(column.dsType),
+                DsType: column.dsType,
+                Checked: 1,
+                ColumnIndex: int64(index),
+            })
+    }
+
+    return datasetFields, nil
+}
+
+// 导入文件并取得内存库以及对应的db类型数据集，返回时持有读锁，查询结束后调用unlock释放
+// 持锁期间内存表不会被重建，连接也不会被关闭
+
+func (f *FileDriver) memTableGet(di *common.DatasetTable) (*SqlDriver, *common.DatasetTable, func(), error) {
+    // 导入后、加读锁前内存库可能被重建，此时重新导入
+    for retry := 0; retry < fileLoadRetry; retry++ {
+        table, key, err := f.loadTable(di.Info)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+
+        f.tableLock.RLock()
+        memDriver := f.memDriver
+        if memDriver == nil || memDriver.dbConn == nil {
+            f.tableLock.RUnlock()
+            return nil, nil, nil, errors.New(fmt.Sprintf("file datasource [%s] not available", f.datasourceInfo.Name))
+        }
+        if f.tableMap[key] != table {
+            f.tableLock.RUnlock()
+            continue
+        }
+
+        memTable := *di
+        memTable.Type = common.DatasetTypeDB
+        memTable.Info = table.name
+
+        return memDriver, &memTable, f.tableLock.RUnlock, nil
+    }
+
+    return nil, nil, nil, errors.New(fmt.Sprintf("file [%s] changed during load", di.Info))
+}
+
+// 数据访问转换为内存表的db类型查询
+
+func (f *FileDriver) GetData(ctx context.Context, datasetId string, di *common.DatasetTable, fields []common.DatasetTableField,
+    query *common.DsQuery) (*common.DsResult, error) {
+    if di.Type != common.DatasetTypeExcel {
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not define", di.Type))
+    }
+
+    memDriver, memTable, unlock, err := f.memTableGet(di)
+    if err != nil {
+        return nil, err
+    }
+    defer unlock()
+
+    return memDriver.GetData(ctx, datasetId, memTable, fields, query)
+}
+
+func (f *FileDriver) GetFieldValues(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, fieldName string, search string, limit int,
+    options *common.DsValueOptions) ([]common.DsFieldValue, error) {
+    if di.Type != common.DatasetTypeExcel {
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not define", di.Type))
+    }
+
+    memDriver, memTable, unlock, err := f.memTableGet(di)
+    if err != nil {
+        return nil, err
+    }
+    defer unlock()
+
+    return memDriver.GetFieldValues(ctx, memTable, fields, fieldName, search, limit, options)
+}
+
+func (f *FileDriver) GetFieldProfiles(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField) (map[string]common.FieldProfile, error) {
+    if di.Type != common.DatasetTypeExcel {
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not define", di.Type))
+    }
+
+    memDriver, memTable, unlock, err := f.memTableGet(di)
+    if err != nil {
+        return nil, err
+    }
+    defer unlock()
+
+    return memDriver.GetFieldProfiles(ctx, memTable, fields)
+}
+
+// 查看数据记录的连接状态
+
+func (f *FileDriver) GetDBConnStatus() DBConnStatus {
+    return f.datasourceInfo.Status
+}
+
+// CheckDBConnStatus 获取连接状态，ConnSuccess: 连接可用，ConnFail：连接不可用
+
+func (f *FileDriver) CheckDBConnStatus() DBConnStatus {
+    f.tableLock.RLock()
+    memDriver := f.memDriver
+    f.tableLock.RUnlock()
+    if memDriver == nil {
+        // 重新建立连接
+        err := f.DBConn()
+        if err != nil {
+            return ConnFail
+        }
+
+        return ConnSuccess
+    }
+
+    info, err := os.Stat(f.datasourceInfo.Config.DataBase)
+    if err != nil || !info.IsDir() {
+        f.datasourceInfo.Status = ConnFail
+        return ConnFail
+    }
+
+    status := memDriver.CheckDBConnStatus()
+    if status != ConnSuccess {
+        f.datasourceInfo.Status = ConnFail
+    }
+
+    return status
+}
+
+// DBRecovery 重新建立连接，内存表在下次访问时重新导入
+
+func (f *FileDriver) DBRecovery() error {
+    _ = f.Close()
+
+    return f.DBConn()
+}
+
+// 删除连接
+
+func (f *FileDriver) Close() error {
+    f.tableLock.Lock()
+    defer f.tableLock.Unlock()
+
+    if f.memDriver != nil {
+        _ = f.memDriver.Close()
+    }
+    f.memDriver = nil
+    f.tableMap = nil
+
+    return nil
+}
+
+func NewFileDriver(datasourceInfo common.DatasourceTable) (DBDriver, error) {
+    source := &FileDriver{datasourceInfo: datasourceInfo}
+    err := source.DBConn()
+    if err != nil {
+        return nil, err
+    }
+
+    return source, nil
+}
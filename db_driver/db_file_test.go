@@ -0,0 +1,71 @@
+package db_driver
+
+import (
+    "context"
+    "github.com/bingLAN/data_driver/common"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// 查询与重建内存库并发执行，查询要么成功要么返回数据源不可用
+
+func TestFileDriverConcurrent(t *testing.T) {
+    dir := t.TempDir()
+    err := os.WriteFile(filepath.Join(dir, "flow.csv"), []byte("province,bytes\n北京市,100\n广东省,500\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    driver, err := NewFileDriver(common.DatasourceTable{Name: "file_concurrent", Config: common.Configuration{DataBase: dir}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer driver.Close()
+
+    di := &common.DatasetTable{DatasetId: "flow", Type: common.DatasetTypeExcel, Info: "flow.csv"}
+    fields, err := driver.GetDataFields(context.Background(), *di)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var wg sync.WaitGroup
+    errCh := make(chan error, 100)
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 20; j++ {
+                res, err := driver.GetData(context.Background(), di.DatasetId, di, fields, &common.DsQuery{})
+                if err != nil && !strings.Contains(err.Error(), "not available") {
+                    errCh <- err
+                } else if err == nil && len(res.TableRow) != 2 {
+                    t.Errorf("rows len %d, want 2", len(res.TableRow))
+                }
+            }
+        }()
+    }
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    for recovering := true; recovering; {
+        select {
+        case <-done:
+            recovering = false
+        default:
+            if err = driver.DBRecovery(); err != nil {
+                t.Fatal(err)
+            }
+            time.Sleep(time.Millisecond)
+        }
+    }
+    close(errCh)
+    for err = range errCh {
+        t.Error(err)
+    }
+}
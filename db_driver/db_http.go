@@ -0,0 +1,667 @@
+package db_driver
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// HttpDriver http/json接口数据源，数据集类型为api，Info为接口路径
+// 分页通过offset/limit查询参数下发，排序为sort/order参数，过滤条件树以json形式作为filter参数
+
+type HttpDriver struct {
+    client              *http.Client
+    datasourceInfo      common.DatasourceTable
+}
+
+const (
+    httpDefaultOffsetParam = "offset"
+    httpDefaultLimitParam = "limit"
+    httpSampleSize = 100        // 推断字段时采样的记录数
+    httpErrBodySize = 512
+    httpDefaultBodySize = 64 << 20     // 响应体默认上限
+)
+
+// DBConn 创建http客户端
+
+func (h *HttpDriver) DBConn() error {
+    if h.datasourceInfo.Config.BaseUrl == "" {
+        h.datasourceInfo.Status = ConnFail
+        return errors.New(fmt.Sprintf("http datasource [%s] baseUrl is empty", h.datasourceInfo.Name))
+    }
+    _, err := url.Parse(h.datasourceInfo.Config.BaseUrl)
+    if err != nil {
+        h.datasourceInfo.Status = ConnFail
+        return err
+    }
+
+    transport := &http.Transport{
+        Proxy: http.ProxyFromEnvironment,
+        DialContext: (&net.Dialer{
+            Timeout: time.Duration(h.datasourceInfo.Config.ConnectTimeout) * time.Second,
+        }).DialContext,
+        MaxIdleConns: int(h.datasourceInfo.Config.MaxIdleTime),
+        MaxConnsPerHost: int(h.datasourceInfo.Config.MaxPoolSize),
+    }
+    h.client = &http.Client{
+        Transport: transport,
+        Timeout: time.Duration(h.datasourceInfo.Config.QueryTimeout) * time.Second,
+    }
+    h.datasourceInfo.Status = ConnSuccess
+
+    return nil
+}
+
+// 拼接接口地址，ExtraParams作为公共查询参数追加
+
+func (h *HttpDriver) requestUrl(path string, params url.Values) (string, error) {
+    base := strings.TrimSuffix(h.datasourceInfo.Config.BaseUrl, "/")
+    if path != "" && !strings.HasPrefix(path, "/") {
+        path = "/" + path
+    }
+    u, err := url.Parse(base + path)
+    if err != nil {
+        return "", err
+    }
+
+    query := u.Query()
+    if h.datasourceInfo.Config.ExtraParams != "" {
+        extra, err := url.ParseQuery(h.datasourceInfo.Config.ExtraParams)
+        if err != nil {
+            return "", err
+        }
+        for k, v := range extra {
+            query[k] = v
+        }
+    }
+    for k, v := range params {
+        query[k] = v
+    }
+    u.RawQuery = query.Encode()
+
+    return u.String(), nil
+}
+
+// 登记到执行中查询的请求地址，仅保留驱动生成的分页、排序、过滤参数
+// 地址中的用户信息、BaseUrl以及ExtraParams中的参数常含token，不对外展示
+
+func (h *HttpDriver) requestUrlMask(path string, params url.Values) string {
+    reqUrl, err := h.requestUrl(path, nil)
+    if err != nil {
+        return ""
+    }
+    u, err := url.Parse(reqUrl)
+    if err != nil {
+        return ""
+    }
+    u.User = nil
+    u.RawQuery = params.Encode()
+
+    return u.String()
+}
+
+func (h *HttpDriver) setAuthHeader(req *http.Request) {
+    auth := strings.TrimSpace(h.datasourceInfo.Config.AuthHeader)
+    if auth == "" {
+        return
+    }
+
+    if index := strings.Index(auth, ":"); index > 0 && !strings.Contains(auth[:index], " ") {
+        req.Header.Set(strings.TrimSpace(auth[:index]), strings.TrimSpace(auth[index+1:]))
+    } else {
+        req.Header.Set("Authorization", auth)
+    }
+}
+
+func (h *HttpDriver) doGet(ctx context.Context, path string, params url.Values) ([]byte, error) {
+    if h.client == nil {
+        return nil, errors.New(fmt.Sprintf("http datasource [%s] not available", h.datasourceInfo.Name))
+    }
+
+    reqUrl, err := h.requestUrl(path, params)
+    if err != nil {
+        return nil, err
+    }
+    runningQuerySql(ctx, h.requestUrlMask(path, params))
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/json")
+    h.setAuthHeader(req)
+
+    resp, err := h.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    // 多读一个字节判断响应体是否超过上限
+    maxBodySize := h.maxBodySize()
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize + 1))
+    if err != nil {
+        return nil, err
+    }
+    if int64(len(body)) > maxBodySize {
+        return nil, errors.New(fmt.Sprintf("http request [%s] response body exceeds %d bytes", path, maxBodySize))
+    }
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        if len(body) > httpErrBodySize {
+            body = body[:httpErrBodySize]
+        }
+        return nil, errors.New(fmt.Sprintf("http request [%s] status [%d]: %s", path, resp.StatusCode, string(body)))
+    }
+
+    return body, nil
+}
+
+// 解析JSONPath，支持 $.a.b、a.b[0]、$['a b'] 形式
+
+func jsonPathSplit(path string) ([]interface{}, error) {
+    var steps []interface{}
+
+    path = strings.TrimSpace(path)
+    path = strings.TrimPrefix(path, "$")
+    for len(path) > 0 {
+        switch path[0] {
+        case '.':
+            path = path[1:]
+        case '[':
+            end := strings.Index(path, "]")
+            if end < 0 {
+                return nil, errors.New(fmt.Sprintf("json path [%s] bracket not closed", path))
+            }
+            inner := strings.TrimSpace(path[1:end])
+            path = path[end+1:]
+            if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+                steps = append(steps, inner[1:len(inner)-1])
+            } else {
+                index, err := strconv.Atoi(inner)
+                if err != nil {
+                    return nil, errors.New(fmt.Sprintf("json path index [%s] invalid", inner))
+                }
+                steps = append(steps, index)
+            }
+        default:
+            end := strings.IndexAny(path, ".[")
+            if end < 0 {
+                end = len(path)
+            }
+            steps = append(steps, path[:end])
+            path = path[end:]
+        }
+    }
+
+    return steps, nil
+}
+
+// 按JSONPath找到行数组，每行保留原始json以保证字段顺序
+
+func jsonRows(body []byte, rowsPath string) ([]json.RawMessage, error) {
+    steps, err := jsonPathSplit(rowsPath)
+    if err != nil {
+        return nil, err
+    }
+
+    node := json.RawMessage(body)
+    for _, step := range steps {
+        switch key := step.(type) {
+        case string:
+            var obj map[string]json.RawMessage
+            if err = json.Unmarshal(node, &obj); err != nil {
+                return nil, errors.New(fmt.Sprintf("json path [%s] key [%s] is not on an object", rowsPath, key))
+            }
+            child, ok := obj[key]
+            if !ok {
+                return nil, errors.New(fmt.Sprintf("json path [%s] key [%s] not found", rowsPath, key))
+            }
+            node = child
+        case int:
+            var arr []json.RawMessage
+            if err = json.Unmarshal(node, &arr); err != nil {
+                return nil, errors.New(fmt.Sprintf("json path [%s] index [%d] is not on an array", rowsPath, key))
+            }
+            if key < 0 || key >= len(arr) {
+                return nil, errors.New(fmt.Sprintf("json path [%s] index [%d] out of range", rowsPath, key))
+            }
+            node = arr[key]
+        }
+    }
+
+    var rows []json.RawMessage
+    if bytes.Equal(bytes.TrimSpace(node), []byte("null")) {
+        return rows, nil
+    }
+    if err = json.Unmarshal(node, &rows); err != nil {
+        return nil, errors.New(fmt.Sprintf("json path [%s] is not an array", rowsPath))
+    }
+
+    return rows, nil
+}
+
+// 按出现顺序读取json对象的key
+
+func jsonObjectKeys(raw json.RawMessage) ([]string, error) {
+    decoder := json.NewDecoder(bytes.NewReader(raw))
+    token, err := decoder.Token()
+    if err != nil {
+        return nil, err
+    }
+    if delim, ok := token.(json.Delim); !ok || delim != '{' {
+        return nil, errors.New("json row is not an object")
+    }
+
+    var keys []string
+    for decoder.More() {
+        token, err = decoder.Token()
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, token.(string))
+        // 跳过value
+        var value json.RawMessage
+        if err = decoder.Decode(&value); err != nil {
+            return nil, err
+        }
+    }
+
+    return keys, nil
+}
+
+// 将json值转换为行数据，嵌套对象/数组保留为json文本
+
+func jsonRowValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case json.Number:
+        if i, err := v.Int64(); err == nil {
+            return i
+        }
+        f, _ := v.Float64()
+        return f
+    case map[string]interface{}, []interface{}:
+        b, _ := json.Marshal(v)
+        return string(b)
+    default:
+        return v
+    }
+}
+
+func jsonRowDecode(raw json.RawMessage) (common.SqlRes, error) {
+    var obj map[string]interface{}
+    decoder := json.NewDecoder(bytes.NewReader(raw))
+    decoder.UseNumber()
+    if err := decoder.Decode(&obj); err != nil {
+        return nil, err
+    }
+
+    row := make(common.SqlRes, len(obj))
+    for k, v := range obj {
+        row[k] = jsonRowValue(v)
+    }
+
+    return row, nil
+}
+
+func getDatasetTypeJson(value interface{}) int64 {
+    switch v := value.(type) {
+    case int64:
+        return common.DSTypeInt
+    case float64:
+        return common.DSTypeDEC
+    case bool:
+        return common.DSTypeBit
+    case string:
+        if _, ok := parseTimeText(v); ok {
+            return common.DSTypeTime
+        }
+        return common.DSTypeVar
+    default:
+        return common.DSTypeVar
+    }
+}
+
+func jsonTypeName(dsType int64) string {
+    switch dsType {
+    case common.DSTypeInt:
+        return "integer"
+    case common.DSTypeDEC:
+        return "number"
+    case common.DSTypeTime:
+        return "datetime"
+    case common.DSTypeBit:
+        return "boolean"
+    default:
+        return "string"
+    }
+}
+
+// 合并两次采样推断出的类型，整形与浮点合并为浮点，其余冲突退化为文本
+
+func mergeDatasetType(old, now int64) int64 {
+    switch {
+    case old == now:
+        return old
+    case (old == common.DSTypeInt && now == common.DSTypeDEC) || (old == common.DSTypeDEC && now == common.DSTypeInt):
+        return common.DSTypeDEC
+    default:
+        return common.DSTypeVar
+    }
+}
+
+func (h *HttpDriver) fetchRows(ctx context.Context, path string, params url.Values) ([]common.SqlRes, []json.RawMessage, error) {
+    body, err := h.doGet(ctx, path, params)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    raws, err := jsonRows(body, h.datasourceInfo.Config.RowsPath)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var rows []common.SqlRes
+    for index, _ := range raws {
+        row, err := jsonRowDecode(raws[index])
+        if err != nil {
+            return nil, nil, err
+        }
+        rows = append(rows, row)
+    }
+
+    return rows, raws, nil
+}
+
+func (h *HttpDriver) offsetParam() string {
+    if h.datasourceInfo.Config.OffsetParam != "" {
+        return h.datasourceInfo.Config.OffsetParam
+    }
+    return httpDefaultOffsetParam
+}
+
+func (h *HttpDriver) maxBodySize() int64 {
+    if h.datasourceInfo.Config.MaxBodySize != 0 {
+        return int64(h.datasourceInfo.Config.MaxBodySize)
+    }
+    return httpDefaultBodySize
+}
+
+func (h *HttpDriver) limitParam() string {
+    if h.datasourceInfo.Config.LimitParam != "" {
+        return h.datasourceInfo.Config.LimitParam
+    }
+    return httpDefaultLimitParam
+}
+
+// 根据采样记录获取所有field
+
+func (h *HttpDriver) GetDataFields(ctx context.Context, dsTable common.DatasetTable) ([]common.DatasetTableField, error) {
+    if dsTable.Type != common.DatasetTypeAPI {
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not support", dsTable.Type))
+    }
+
+    params := url.Values{}
+    params.Set(h.offsetParam(), "0")
+    params.Set(h.limitParam(), strconv.Itoa(httpSampleSize))
+    rows, raws, err := h.fetchRows(ctx, dsTable.Info, params)
+    if err != nil {
+        return nil, err
+    }
+    if len(rows) == 0 {
+        return nil, errors.New(fmt.Sprintf("api [%s] returns no record to sample", dsTable.Info))
+    }
+
+    // 字段顺序按首次出现顺序，类型按全部采样值合并
+    var names []string
+    typeMap := make(map[string]int64)
+    for index, _ := range raws {
+        keys, err := jsonObjectKeys(raws[index])
+        if err != nil {
+            return nil, err
+        }
+        for _, key := range keys {
+            value := rows[index][key]
+            if value == nil {
+                if _, ok := typeMap[key]; !ok {
+                    names = append(names, key)
+                    typeMap[key] = -1
+                }
+                continue
+            }
+
+            dsType := getDatasetTypeJson(value)
+            old, ok := typeMap[key]
+            switch {
+            case !ok:
+                names = append(names, key)
+                typeMap[key] = dsType
+            case old < 0:
+                typeMap[key] = dsType
+            default:
+                typeMap[key] = mergeDatasetType(old, dsType)
+            }
+        }
+    }
+
+    var datasetFields []common.DatasetTableField
+    for index, name := range names {
+        dsType := typeMap[name]
+        if dsType < 0 {
+            dsType = common.DSTypeVar
+        }
+        datasetFields = append(datasetFields,
+            common.DatasetTableField{
+                FieldId: getDatasetFieldId(),
+                DatasetId: dsTable.DatasetId,
+                OriginName: name,
+                Name: name,
+                GroupType: common.FieldDimension,
+                Type: jsonTypeName(dsType),
+                DsType: dsType,
+                Checked: 1,
+                ColumnIndex: int64(index),
+            })
+    }
+
+    return datasetFields, nil
+}
+
+// 接口返回的是分页数据，无法统计字段的全部取值
+
+func (h *HttpDriver) GetFieldValues(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, fieldName string, search string, limit int,
+    options *common.DsValueOptions) ([]common.DsFieldValue, error) {
+    return nil, errors.New(fmt.Sprintf("api datasource [%s] not support field values", h.datasourceInfo.Name))
+}
+
+func (h *HttpDriver) GetFieldProfiles(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField) (map[string]common.FieldProfile, error) {
+    return nil, errors.New(fmt.Sprintf("api datasource [%s] not support field profiles", h.datasourceInfo.Name))
+}
+
+// 根据接口返回结果，封装DsResult结构
+
+func (h *HttpDriver) GetData(ctx context.Context, datasetId string, di *common.DatasetTable, fields []common.DatasetTableField,
+    query *common.DsQuery) (*common.DsResult, error) {
+    if di.Type != common.DatasetTypeAPI {
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not define", di.Type))
+    }
+    if query == nil {
+        query = &common.DsQuery{}
+    }
+    if query.Aggregate {
+        // 接口数据源不支持服务端聚合
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support aggregate", h.datasourceInfo.Name))
+    }
+    if query.Cursor != "" {
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support cursor paging", h.datasourceInfo.Name))
+    }
+    if len(query.Variables) > 0 {
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support sql variables", h.datasourceInfo.Name))
+    }
+    if query.Compare != nil {
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support compare", h.datasourceInfo.Name))
+    }
+    if query.Pivot != nil {
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support pivot", h.datasourceInfo.Name))
+    }
+    if query.TopN != nil {
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support top n", h.datasourceInfo.Name))
+    }
+    if len(query.Granularity) > 0 {
+        return nil, errors.New(fmt.Sprintf("api datasource [%s] not support time granularity", h.datasourceInfo.Name))
+    }
+    for index, _ := range fields {
+        if fields[index].ExtField == 1 {
+            return nil, errors.New(fmt.Sprintf("api datasource [%s] not support calculated field [%s]", h.datasourceInfo.Name, fields[index].Name))
+        }
+    }
+
+    params := url.Values{}
+    // 仅在分页或limit字段有效时才下发，limit多取一条用于判断是否还有数据
+    fetch := pageFetchQuery(query)
+    if fetch.Offset != 0 || fetch.Limit != 0 {
+        params.Set(h.offsetParam(), strconv.Itoa(fetch.Offset))
+    }
+    if fetch.Limit != 0 {
+        params.Set(h.limitParam(), strconv.Itoa(fetch.Limit))
+    }
+    // 排序键以逗号分隔的平行列表下发：sort=a,b&order=asc,desc[&nulls=first,last]，字段使用接口原始字段名
+    sorts, err := sortCheck(query.Sorts, fields)
+    if err != nil {
+        return nil, err
+    }
+    if sorts != nil {
+        var names, orders, nulls []string
+        hasNulls := false
+        fieldMap := fieldNameMapBuild(fields)
+        for _, sort := range sorts {
+            names = append(names, fieldMap[sort.Field].OriginName)
+            orders = append(orders, sort.Order)
+            nulls = append(nulls, sort.Nulls)
+            hasNulls = hasNulls || sort.Nulls != ""
+        }
+        params.Set("sort", strings.Join(names, ","))
+        params.Set("order", strings.Join(orders, ","))
+        if hasNulls {
+            params.Set("nulls", strings.Join(nulls, ","))
+        }
+    }
+    // 过滤条件校验后以json形式下发，由接口自行解释
+    checked, err := filterCheck(query.Filter, fields)
+    if err != nil {
+        return nil, err
+    }
+    if checked != nil {
+        filterOriginRename(checked, fieldNameMapBuild(fields))
+        filterJson, err := json.Marshal(checked)
+        if err != nil {
+            return nil, err
+        }
+        params.Set("filter", string(filterJson))
+    }
+
+    rows, _, err := h.fetchRows(ctx, di.Info, params)
+    if err != nil {
+        return nil, err
+    }
+    rows, hasMore := pageTrim(rows, query)
+
+    // 接口没有统一的总数协议，只在无需统计时给出总行数
+    total, _ := pageTotalKnown(len(rows), hasMore, query)
+
+    // 接口无法按列取数，未选中的列在本地去掉，改名字段按展示名输出
+    checkedFields, err := checkedFieldsBuild(fields)
+    if err != nil {
+        return nil, err
+    }
+    rows = rowRename(rows, checkedFields)
+
+    dsRes := dsResultBuild(rows, checkedFields, nil)
+    dsPageFill(dsRes, query, total, hasMore)
+
+    return dsRes, nil
+}
+
+// 查看数据记录的连接状态
+
+func (h *HttpDriver) GetDBConnStatus() DBConnStatus {
+    return h.datasourceInfo.Status
+}
+
+// CheckDBConnStatus 获取连接状态，ConnSuccess: 连接可用，ConnFail：连接不可用
+// 能收到响应即认为可用，认证失败及服务端错误视为不可用
+
+func (h *HttpDriver) CheckDBConnStatus() DBConnStatus {
+    if h.client == nil {
+        // 重新建立连接
+        err := h.DBConn()
+        if err != nil {
+            return ConnFail
+        }
+    }
+
+    reqUrl, err := h.requestUrl("", nil)
+    if err != nil {
+        h.datasourceInfo.Status = ConnFail
+        return ConnFail
+    }
+    req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+    if err != nil {
+        h.datasourceInfo.Status = ConnFail
+        return ConnFail
+    }
+    h.setAuthHeader(req)
+
+    client := *h.client
+    client.Timeout = time.Duration(h.datasourceInfo.Config.ConnectTimeout) * time.Second
+    resp, err := client.Do(req)
+    if err != nil {
+        h.datasourceInfo.Status = ConnFail
+        return ConnFail
+    }
+    _ = resp.Body.Close()
+    if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode >= 500 {
+        h.datasourceInfo.Status = ConnFail
+        return ConnFail
+    }
+
+    h.datasourceInfo.Status = ConnSuccess
+    return ConnSuccess
+}
+
+// DBRecovery 重新建立连接
+
+func (h *HttpDriver) DBRecovery() error {
+    _ = h.Close()
+
+    return h.DBConn()
+}
+
+// 删除连接
+
+func (h *HttpDriver) Close() error {
+    if h.client != nil {
+        h.client.CloseIdleConnections()
+    }
+    h.client = nil
+
+    return nil
+}
+
+func NewHttpDriver(datasourceInfo common.DatasourceTable) (DBDriver, error) {
+    source := &HttpDriver{datasourceInfo: datasourceInfo}
+    err := source.DBConn()
+    if err != nil {
+        return nil, err
+    }
+
+    return source, nil
+}
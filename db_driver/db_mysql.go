@@ -2,31 +2,26 @@ package db_driver
 
 import (
     "context"
-    "errors"
     "fmt"
     "github.com/bingLAN/data_driver/common"
     "gorm.io/driver/mysql"
     "gorm.io/gorm"
-    "sort"
     "strings"
-    "time"
 )
 
-type MysqlDriver struct {
-    dbConn              *gorm.DB
-    datasourceInfo      common.DatasourceTable
+type MysqlDialect struct {
 }
 
-// DBConn 创建连接
-// 建立连接的同时根据结果更新数据库中source表对应状态信息
+func (m MysqlDialect) Name() string {
+    return DatasourceMYSQL
+}
 
-func (m *MysqlDriver) DBConn() error {
+func (m MysqlDialect) Open(config common.Configuration) (gorm.Dialector, error) {
     // 封装dsn
     dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-        m.datasourceInfo.Config.Username, m.datasourceInfo.Config.Password, m.datasourceInfo.Config.Host, m.datasourceInfo.Config.Port,
-        m.datasourceInfo.Config.DataBase)
-    if m.datasourceInfo.Config.ExtraParams != "" {
-        dsn = dsn + "&" + m.datasourceInfo.Config.ExtraParams
+        config.Username, config.Password, config.Host, config.Port, config.DataBase)
+    if config.ExtraParams != "" {
+        dsn = dsn + "&" + config.ExtraParams
     }
 
     mysqlConfig := mysql.Config{
@@ -35,282 +30,42 @@ func (m *MysqlDriver) DBConn() error {
         SkipInitializeWithVersion: false,   // 根据版本自动配置
     }
 
-    // 创建连接池
-    db, err := gorm.Open(mysql.New(mysqlConfig), &gorm.Config{})
-    if err != nil {
-        return err
-    }
-
-    sqlDB, _ := db.DB()
-    sqlDB.SetMaxIdleConns(int(m.datasourceInfo.Config.MaxIdleTime))
-    sqlDB.SetMaxOpenConns(int(m.datasourceInfo.Config.MaxPoolSize))
-    sqlDB.SetConnMaxIdleTime(time.Duration(m.datasourceInfo.Config.ConnectTimeout) * time.Second)
-    m.dbConn = db
-    m.datasourceInfo.Status = ConnSuccess
-
-    return nil
-}
-
-func (m *MysqlDriver) sqlSortFieldCheck(fields []common.DatasetTableField, sortNames []string) error {
-    fieldNameMap := make(map[string]struct{})
-    for index, _ := range fields {
-        fieldNameMap[fields[index].Name] = struct{}{}
-    }
-
-    // 检查sortName是否在数据集中有定义
-    for index, _ := range sortNames {
-        if _, ok := fieldNameMap[sortNames[index]]; !ok {
-            // sort字段未定义
-            return errors.New(fmt.Sprintf("sort name [%s] not define in dataset", sortNames[index]))
-        }
-    }
-
-    return nil
-}
-
-// 构建排序字段
-func (m *MysqlDriver) sqlSortBuild(fields []common.DatasetTableField, sortNames []string, sortOpt string) (string, error) {
-    if sortNames == nil {
-        // 不需要排序
-        return "", nil
-    }
-
-    // 检查排序字段是否都存在
-    fieldErr := m.sqlSortFieldCheck(fields, sortNames)
-    if fieldErr != nil {
-        return "", fieldErr
-    }
-    output := strings.Join(sortNames, ` `)
-    sortSql := fmt.Sprintf("%s %s", output, sortOpt)
-
-    return sortSql, nil
-}
-
-func (m *MysqlDriver) sqlBuildDB(di *common.DatasetTable, fields []common.DatasetTableField, offset, limit int, sortNames []string, sortOpt string, filter string) (string, error) {
-    var sql string
-
-    sortSql, err := m.sqlSortBuild(fields, sortNames, sortOpt)
-    if err != nil {
-        return "", err
-    }
-    if filter == "" {
-        sql = fmt.Sprintf("select * from %s", di.Info)
-    } else {
-        sql = fmt.Sprintf("select * from %s where %s", di.Info, filter)
-    }
-
-
-    if sortSql != "" {
-        sql += fmt.Sprintf(" order by %s", sortSql)
-    }
-
-    // 仅在分页或limit字段有效时才构建
-    if !(offset == 0 && limit == 0) {
-        sql += fmt.Sprintf(" limit %d offset %d", limit, offset)
-    }
-
-    return sql, nil
-}
-
-func (m *MysqlDriver) sqlBuildSQL(di *common.DatasetTable, fields []common.DatasetTableField, offset, limit int, sortNames []string, sortOpt string, filter string) (string, error) {
-    var sql string
-
-    sortSql, err := m.sqlSortBuild(fields, sortNames, sortOpt)
-    if err != nil {
-        return "", err
-    }
-    if filter == "" {
-        sql = fmt.Sprintf("select * from (%s) t", di.Info)
-    } else {
-        sql = fmt.Sprintf("select * from (%s) t where %s", di.Info, filter)
-    }
-
-
-    if sortSql != "" {
-        sql += fmt.Sprintf(" order by %s", sortSql)
-    }
-
-    // 仅在分页或limit字段有效时才构建
-    if !(offset == 0 && limit == 0) {
-        sql += fmt.Sprintf(" limit %d offset %d", limit, offset)
-    }
-
-    return sql, nil
-}
-
-func (m *MysqlDriver) sqlBuild(di *common.DatasetTable, fields []common.DatasetTableField, offset, limit int, sortNames []string, sortOpt string, filter string) (string, error) {
-
-    // 根据db/sql类型分别组装sql
-    var sql string
-    var err error
-    switch di.Type {
-    case common.DatasetTypeDB:
-        sql, err = m.sqlBuildDB(di, fields, offset, limit, sortNames, sortOpt, filter)
-    case common.DatasetTypeSQL:
-        sql, err = m.sqlBuildSQL(di, fields, offset, limit, sortNames, sortOpt, filter)
-    default:
-        return "", errors.New(fmt.Sprintf("dataset type [%s] not define", m.datasourceInfo.Type))
-    }
-    if err != nil {
-        return "", err
-    }
-
-    return sql, nil
-}
-
-func (m *MysqlDriver) sqlExec(di *common.DatasetTable, fields []common.DatasetTableField, offset, limit int, sortNames []string, sortOpt string, filter string) ([]common.SqlRes, error) {
-    sql, err := m.sqlBuild(di, fields, offset, limit, sortNames, sortOpt, filter)
-    if err != nil {
-        return nil, err
-    }
-
-    // 执行sql
-    var result []common.SqlRes
-    dbErr := m.dbConn.Raw(sql).Scan(&result).Error
-    if dbErr != nil {
-        return nil, dbErr
-    }
-
-    return result, nil
+    return mysql.New(mysqlConfig), nil
 }
 
-// 遍历TableRow，根据维度信息以及列序号封装X结构
-func (m *MysqlDriver) xAxis(sqlRes []common.SqlRes, fields []common.DatasetTableField) ([]string, FieldDefList) {
-    var dimensionList FieldDefList
-
-    // 整理维度field，并按照ColumnIndex排序
-    for index, _ := range fields {
-        field := fields[index]
-        if field.GroupType == common.FieldDimension {
-            dimensionList = append(dimensionList,
-                FieldDef{
-                    Name: field.Name,
-                    GroupType: field.GroupType,
-                    ColumnIndex: field.ColumnIndex,
-                },
-            )
-        }
-    }
-
-    sort.Sort(dimensionList)
-
-    // 根据维度序列，组装xAxis
-    var xAxis []string
-    for index, _ := range sqlRes {
-        row := sqlRes[index]
-        // 多个维度字段之间用"\n"隔开
-        var names []string
-        for _, dimension := range dimensionList {
-            if v, ok := row[dimension.Name]; ok {
-                s := fmt.Sprintf("%v", v)
-                names = append(names, s)
-            }
-        }
-        x := strings.Join(names, "\n")
-
-        xAxis = append(xAxis, x)
-    }
-
-    return xAxis, dimensionList
+func (m MysqlDialect) QuoteIdent(name string) string {
+    return quoteIdentWith(name, "`")
 }
 
-func (m *MysqlDriver) getDimensionFromSqlRes(row common.SqlRes, dimensionList FieldDefList) []string {
-    var dimension []string
-    // 组装维度值
-    for _, dim := range dimensionList {
-        if dimV, ok := row[dim.Name]; ok {
-            dimStr := fmt.Sprintf("%v", dimV)
-            dimension = append(dimension, dimStr)
-        }
-    }
-
-    return dimension
+func (m MysqlDialect) LimitOffset(limit, offset int) (string, []interface{}) {
+    return defaultLimitOffset(limit, offset)
 }
 
-// 根据指标字段分类展示各维度的value值
-func (m *MysqlDriver) series(sqlRes []common.SqlRes, fields []common.DatasetTableField, dimensionList FieldDefList) []common.DsSeries {
-    var quotaList FieldDefList
-
-    // 整理指标field，并按照ColumnIndex排序
-    for index, _ := range fields {
-        field := fields[index]
-        if field.GroupType == common.FieldQuota {
-            quotaList = append(quotaList,
-                FieldDef{
-                    Name: field.Name,
-                    GroupType: field.GroupType,
-                    ColumnIndex: field.ColumnIndex,
-                },
-            )
-        }
-    }
+// mysql不支持nulls first/last，以is null排序模拟；mysql默认空值最小
 
-    var quotaMap map[string][]common.DsData
-    quotaMap = make(map[string][]common.DsData)
-    // 遍历每一行res
-    for index, _ := range sqlRes {
-        // 组装改行的所有维度值
-        dimension := m.getDimensionFromSqlRes(sqlRes[index], dimensionList)
-        for _, quota := range quotaList {
-            quotaName := quota.Name
-            // 组装该指标的data数据
-            quotaMap[quotaName] = append(quotaMap[quotaName],
-                common.DsData{
-                    Value: sqlRes[index][quotaName],
-                    Name:  dimension,
-                },
-            )
-        }
+func (m MysqlDialect) OrderBy(column string, order string, nulls string) string {
+    switch nulls {
+    case common.SortNullsFirst:
+        return fmt.Sprintf("%s is null desc, %s %s", column, column, order)
+    case common.SortNullsLast:
+        return fmt.Sprintf("%s is null asc, %s %s", column, column, order)
     }
 
-    var series []common.DsSeries
-    // 每行根据指标字段，分类维度-指标值
-    for k, v := range quotaMap {
-        series = append(series,
-            common.DsSeries{
-                Name: k,
-                Data: v,
-            },
-        )
-    }
-
-    return series
-}
-
-// 根据sql执行结果，封装DsResult结构
-
-func (m *MysqlDriver) GetData(datasetId string, di *common.DatasetTable, fields []common.DatasetTableField,
-    offset, limit int, sortNames []string, sortOpt string, filter string) (*common.DsResult, error) {
-    sqlRes, err := m.sqlExec(di, fields, offset, limit, sortNames, sortOpt, filter)
-    if err != nil {
-        return nil, err
-    }
-
-    var dsRes common.DsResult
-    var dimensionList FieldDefList
-
-    dsRes.X, dimensionList = m.xAxis(sqlRes, fields)
-    dsRes.Fields = fields
-    dsRes.TableRow = sqlRes
-
-    dsRes.Series = m.series(sqlRes, fields, dimensionList)
-
-    return &dsRes, nil
+    return column + " " + order
 }
 
-func (m *MysqlDriver) buildDBTypeSQL(table string) string {
-    return fmt.Sprintf("SELECT * FROM %s LIMIT 1", table)
-}
+// mysql子查询必须带别名
 
-func (m *MysqlDriver) buildSqlTypeSQL(sql string) string {
-    return  fmt.Sprintf("%s LIMIT 1", sql)
+func (m MysqlDialect) SubQuery(sql string) string {
+    return fmt.Sprintf("(%s) t", sql)
 }
 
-func getDatasetTypeMysql(baseType string) int64 {
+func (m MysqlDialect) DatasetType(baseType string) int64 {
     switch strings.ToUpper(baseType) {
-    case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT":
+    case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT",
+        "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
         return common.DSTypeInt
-    case "FLOAT", "DOUBLE", "REAL", "DECIMAL ":
+    case "FLOAT", "DOUBLE", "REAL", "DECIMAL":
         return common.DSTypeDEC
     case "DATE", "DATETIME", "TIMESTAMP", "TIME", "YEAR":
         return common.DSTypeTime
@@ -321,128 +76,78 @@ func getDatasetTypeMysql(baseType string) int64 {
     }
 }
 
-func (m *MysqlDriver) getFieldsBySQL(sql string, datasetId string) ([]common.DatasetTableField, error) {
-    var datasetFields []common.DatasetTableField
-
-    db := m.dbConn
-
-    rows, err := db.Raw(sql).Rows()
-    if err != nil {
-        return nil, err
-    }
-
-    colTypes, err := rows.ColumnTypes()
-    if err != nil {
-        return nil, err
+func (m MysqlDialect) Func(name string, args ...string) string {
+    switch name {
+    case FuncIf:
+        return fmt.Sprintf("if(%s)", strings.Join(args, ", "))
+    case FuncLength:
+        return fmt.Sprintf("char_length(%s)", strings.Join(args, ", "))
     }
 
-    for index, _ := range colTypes {
-        col := colTypes[index]
-        size := int64(0)
-        size, _ = col.Length()
-
-        baseType := col.DatabaseTypeName()
-        dsType := getDatasetTypeMysql(baseType)
+    return defaultFunc(name, args...)
+}
 
-        datasetFields = append(datasetFields,
-            common.DatasetTableField{
-                FieldId: getDatasetFieldId(),
-                DatasetId: datasetId,
-                OriginName: col.Name(),
-                Name: col.Name(),
-                GroupType: common.FieldDimension,
-                Type: baseType,
-                Size: size,
-                DsType: dsType,
-                Checked: 1,
-                ColumnIndex: int64(index),
-            })
+func (m MysqlDialect) TableListSql(schema string) (string, []interface{}) {
+    if schema == "" {
+        return "select table_name from information_schema.tables where table_schema = database()", nil
     }
 
-    return datasetFields, nil
+    return "select table_name from information_schema.tables where table_schema = ?", []interface{}{schema}
 }
 
-// 根据数据集信息获取所有field
-
-func (m *MysqlDriver) GetDataFields(dsTable common.DatasetTable) ([]common.DatasetTableField, error) {
-    var sql string
-
-    switch dsTable.Type {
-    case common.DatasetTypeDB:
-        sql = m.buildDBTypeSQL(dsTable.Info)
-    case common.DatasetTypeSQL:
-        sql = m.buildSqlTypeSQL(dsTable.Info)
-    default:
-        return nil, errors.New(fmt.Sprintf("dataset type [%s] not support", dsTable.Type))
+// mysql以date_format截断时间，结果为文本
 
+func (m MysqlDialect) TimeBucket(column string, gran string) string {
+    switch gran {
+    case common.TimeGranMinute:
+        return fmt.Sprintf("date_format(%s, '%%Y-%%m-%%d %%H:%%i:00')", column)
+    case common.TimeGranHour:
+        return fmt.Sprintf("date_format(%s, '%%Y-%%m-%%d %%H:00:00')", column)
+    case common.TimeGranWeek:
+        return fmt.Sprintf("date_format(date_sub(%s, interval weekday(%s) day), '%%Y-%%m-%%d')", column, column)
+    case common.TimeGranMonth:
+        return fmt.Sprintf("date_format(%s, '%%Y-%%m-01')", column)
+    case common.TimeGranQuarter:
+        return fmt.Sprintf("concat(year(%s), '-', lpad(quarter(%s) * 3 - 2, 2, '0'), '-01')", column, column)
+    case common.TimeGranYear:
+        return fmt.Sprintf("date_format(%s, '%%Y-01-01')", column)
     }
 
-    return m.getFieldsBySQL(sql, dsTable.DatasetId)
-
+    return fmt.Sprintf("date_format(%s, '%%Y-%%m-%%d')", column)
 }
 
-// 查看数据记录的连接状态
-
-func (m *MysqlDriver) GetDBConnStatus() DBConnStatus {
-    return m.datasourceInfo.Status
+func (m MysqlDialect) TimeShift(column string, gran string, n int) string {
+    return fmt.Sprintf("date_add(%s, interval %d %s)", column, n, gran)
 }
 
-// GetDBConnStatus 获取连接状态，ConnSuccess: 连接可用，ConnFail：连接不可用
-
-func (m *MysqlDriver) CheckDBConnStatus() DBConnStatus {
-    if m.dbConn == nil {
-        // 重新建立连接
-        err := m.DBConn()
-        if err != nil {
-            return ConnFail
-        }
-
-        return ConnSuccess
-    }
-
-    sqlDB, _ := m.dbConn.DB()
-    ctx, _ := context.WithTimeout(context.Background(), time.Duration(m.datasourceInfo.Config.ConnectTimeout) * time.Second)
+func (m MysqlDialect) LikeEscape() string {
+    return ""
+}
 
-    err := sqlDB.PingContext(ctx)
-    if err != nil {
-        m.datasourceInfo.Status = ConnFail
-        return ConnFail
-    }
+func (m MysqlDialect) QueryContext(ctx context.Context, queryId string) context.Context {
+    return ctx
+}
 
-    return ConnSuccess
+func (m MysqlDialect) KillQuery(queryId string) (string, []interface{}) {
+    return "", nil
 }
 
-// DBRecovery 重新建立连接
-// 恢复连接的同时根据结果更新数据库中source表对应状态信息
+// mysql字符串内反斜杠为转义符，反引号标识符不支持反斜杠转义
 
-func (m *MysqlDriver) DBRecovery() error {
-    sqlDB, err := m.dbConn.DB()
-    if err == nil {
-        _ = sqlDB.Close()
-    }
-    m.dbConn = nil
+var mysqlLiteralStyle = sqlLiteralStyle{quotes: "'\"`", backslash: "'\""}
 
-    return m.DBConn()
+func (m MysqlDialect) LiteralEnd(sql string, start int) int {
+    return sqlLiteralEnd(sql, start, mysqlLiteralStyle)
 }
 
-// 删除连接
-
-func (m *MysqlDriver) Close() error {
-    sqlDB, err := m.dbConn.DB()
-    if err != nil {
-        _ = sqlDB.Close()
-    }
-    m.dbConn = nil
+func (m MysqlDialect) PlaceholderEscape(text string) (string, error) {
+    return text, nil
+}
 
-    return nil
+func (m MysqlDialect) ApproxDistinct() bool {
+    return false
 }
 
 func NewMysqlDriver(datasourceInfo common.DatasourceTable) (DBDriver, error) {
-    source := &MysqlDriver{datasourceInfo: datasourceInfo}
-    err := source.DBConn()
-    if err != nil {
-        return nil, err
-    }
-
-    return source, nil
-}
\ No newline at end of file
+    return NewSqlDriver(datasourceInfo, MysqlDialect{})
+}
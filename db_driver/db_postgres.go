@@ -0,0 +1,125 @@
+package db_driver
+
+import (
+    "context"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "strings"
+)
+
+type PostgresDialect struct {
+}
+
+func (p PostgresDialect) Name() string {
+    return DatasourcePG
+}
+
+func (p PostgresDialect) Open(config common.Configuration) (gorm.Dialector, error) {
+    // 封装dsn
+    dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable connect_timeout=%d",
+        config.Host, config.Port, config.Username, config.Password, config.DataBase, config.ConnectTimeout)
+    if config.ExtraParams != "" {
+        // postgres的dsn以空格分隔参数
+        dsn = dsn + " " + config.ExtraParams
+    }
+
+    pgConfig := postgres.Config{
+        DSN:                    dsn,
+        PreferSimpleProtocol:   false,
+    }
+
+    return postgres.New(pgConfig), nil
+}
+
+func (p PostgresDialect) QuoteIdent(name string) string {
+    return quoteIdentWith(name, `"`)
+}
+
+func (p PostgresDialect) LimitOffset(limit, offset int) (string, []interface{}) {
+    return defaultLimitOffset(limit, offset)
+}
+
+func (p PostgresDialect) OrderBy(column string, order string, nulls string) string {
+    return defaultOrderBy(column, order, nulls)
+}
+
+// postgres子查询必须带别名
+
+func (p PostgresDialect) SubQuery(sql string) string {
+    return fmt.Sprintf("(%s) t", sql)
+}
+
+func (p PostgresDialect) DatasetType(baseType string) int64 {
+    switch strings.ToUpper(baseType) {
+    case "INT2", "INT4", "INT8", "SMALLINT", "INTEGER", "BIGINT", "SERIAL", "BIGSERIAL":
+        return common.DSTypeInt
+    case "FLOAT4", "FLOAT8", "REAL", "DOUBLE PRECISION", "NUMERIC", "DECIMAL", "MONEY":
+        return common.DSTypeDEC
+    case "DATE", "TIME", "TIMETZ", "TIMESTAMP", "TIMESTAMPTZ":
+        return common.DSTypeTime
+    case "BIT", "VARBIT":
+        return common.DSTypeBit
+    default:
+        return common.DSTypeVar
+    }
+}
+
+func (p PostgresDialect) Func(name string, args ...string) string {
+    return defaultFunc(name, args...)
+}
+
+func (p PostgresDialect) TableListSql(schema string) (string, []interface{}) {
+    if schema == "" {
+        return "select table_name from information_schema.tables where table_schema = current_schema()", nil
+    }
+
+    return "select table_name from information_schema.tables where table_schema = ?", []interface{}{schema}
+}
+
+func (p PostgresDialect) TimeBucket(column string, gran string) string {
+    return fmt.Sprintf("date_trunc('%s', %s)", gran, column)
+}
+
+// postgres的interval不支持quarter，按3个月偏移
+
+func (p PostgresDialect) TimeShift(column string, gran string, n int) string {
+    if gran == common.TimeGranQuarter {
+        return fmt.Sprintf("(%s + interval '%d month')", column, n * 3)
+    }
+
+    return fmt.Sprintf("(%s + interval '%d %s')", column, n, gran)
+}
+
+func (p PostgresDialect) LikeEscape() string {
+    return ""
+}
+
+func (p PostgresDialect) QueryContext(ctx context.Context, queryId string) context.Context {
+    return ctx
+}
+
+func (p PostgresDialect) KillQuery(queryId string) (string, []interface{}) {
+    return "", nil
+}
+
+// postgres标准字符串中反斜杠不是转义符，E'...'中才是；另支持$tag$...$tag$字符串
+
+var postgresLiteralStyle = sqlLiteralStyle{quotes: "'\"", escapeString: true, dollarQuote: true}
+
+func (p PostgresDialect) LiteralEnd(sql string, start int) int {
+    return sqlLiteralEnd(sql, start, postgresLiteralStyle)
+}
+
+func (p PostgresDialect) PlaceholderEscape(text string) (string, error) {
+    return text, nil
+}
+
+func (p PostgresDialect) ApproxDistinct() bool {
+    return false
+}
+
+func NewPostgresDriver(datasourceInfo common.DatasourceTable) (DBDriver, error) {
+    return NewSqlDriver(datasourceInfo, PostgresDialect{})
+}
@@ -0,0 +1,210 @@
+package db_driver
+
+import (
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// 各驱动共用的结果封装
+
+func fieldDefListBuild(fields []common.DatasetTableField, groupType string, granMap map[string]string) FieldDefList {
+    var defList FieldDefList
+
+    for index, _ := range fields {
+        field := fields[index]
+        if field.GroupType == groupType {
+            timeFormat := ""
+            if field.DsType == common.DSTypeTime {
+                timeFormat = timeLabelPattern(&field, granMap[field.Name])
+            }
+            defList = append(defList,
+                FieldDef{
+                    Name: field.Name,
+                    GroupType: field.GroupType,
+                    ColumnIndex: field.ColumnIndex,
+                    TimeFormat: timeFormat,
+                },
+            )
+        }
+    }
+
+    return defList
+}
+
+// 维度值转为展示文本，时间字段按标签格式输出
+
+func dimensionLabel(value interface{}, dim FieldDef) string {
+    if dim.TimeFormat != "" && value != nil {
+        return timeLabel(value, dim.TimeFormat)
+    }
+
+    return fmt.Sprintf("%v", value)
+}
+
+func dimensionFromRow(row common.SqlRes, dimensionList FieldDefList) []string {
+    var dimension []string
+    // 组装维度值
+    for _, dim := range dimensionList {
+        if dimV, ok := row[dim.Name]; ok {
+            dimension = append(dimension, dimensionLabel(dimV, dim))
+        }
+    }
+
+    return dimension
+}
+
+// 选中的字段，按列位置排序
+
+func checkedFieldsBuild(fields []common.DatasetTableField) ([]common.DatasetTableField, error) {
+    var checked []common.DatasetTableField
+    for index, _ := range fields {
+        if fields[index].Checked == 1 {
+            checked = append(checked, fields[index])
+        }
+    }
+    if len(checked) == 0 {
+        return nil, errors.New("dataset has no checked field")
+    }
+
+    sort.SliceStable(checked, func(i, j int) bool {
+        return checked[i].ColumnIndex < checked[j].ColumnIndex
+    })
+
+    return checked, nil
+}
+
+// 去掉结果行中未选中的列(如仅用于排序、游标的列)
+
+func rowProject(rows []common.SqlRes, fields []common.DatasetTableField) {
+    nameMap := make(map[string]struct{})
+    for index, _ := range fields {
+        nameMap[fields[index].Name] = struct{}{}
+    }
+
+    for index, _ := range rows {
+        for k, _ := range rows[index] {
+            if _, ok := nameMap[k]; !ok {
+                delete(rows[index], k)
+            }
+        }
+    }
+}
+
+// 按字段原始名取值并以展示名输出，不在字段列表中的列被去掉
+
+func rowRename(rows []common.SqlRes, fields []common.DatasetTableField) []common.SqlRes {
+    renamed := make([]common.SqlRes, 0, len(rows))
+    for index, _ := range rows {
+        row := make(common.SqlRes)
+        for fieldIndex, _ := range fields {
+            if v, ok := rows[index][fields[fieldIndex].OriginName]; ok {
+                row[fields[fieldIndex].Name] = v
+            }
+        }
+        renamed = append(renamed, row)
+    }
+
+    return renamed
+}
+
+// 根据维度信息以及列序号封装X结构，指标字段分类展示各维度的value值
+// 时间维度的X标签按字段时间格式输出，granMap为各时间维度的分桶粒度
+
+func dsResultBuild(sqlRes []common.SqlRes, fields []common.DatasetTableField, granMap map[string]string) *common.DsResult {
+    var dsRes common.DsResult
+
+    dimensionList := fieldDefListBuild(fields, common.FieldDimension, granMap)
+    sort.Sort(dimensionList)
+    quotaList := fieldDefListBuild(fields, common.FieldQuota, granMap)
+
+    quotaMap := make(map[string][]common.DsData)
+    for index, _ := range sqlRes {
+        dimension := dimensionFromRow(sqlRes[index], dimensionList)
+        // 多个维度字段之间用"\n"隔开
+        dsRes.X = append(dsRes.X, strings.Join(dimension, "\n"))
+
+        for _, quota := range quotaList {
+            quotaName := quota.Name
+            quotaMap[quotaName] = append(quotaMap[quotaName],
+                common.DsData{
+                    Value: sqlRes[index][quotaName],
+                    Name:  dimension,
+                },
+            )
+        }
+    }
+
+    for k, v := range quotaMap {
+        dsRes.Series = append(dsRes.Series,
+            common.DsSeries{
+                Name: k,
+                Data: v,
+            },
+        )
+    }
+
+    dsRes.Fields = fields
+    dsRes.TableRow = sqlRes
+
+    return &dsRes
+}
+
+// 结果中的数值，部分驱动以文本返回decimal等类型
+
+func resultNumber(value interface{}) (float64, bool) {
+    switch v := value.(type) {
+    case string:
+        f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+        return f, err == nil
+    case []byte:
+        f, err := strconv.ParseFloat(strings.TrimSpace(string(v)), 64)
+        return f, err == nil
+    }
+
+    return filterNumber(value)
+}
+
+// 分页时多取一行用于判断是否还有数据
+
+func pageFetchQuery(query *common.DsQuery) *common.DsQuery {
+    fetch := *query
+    if fetch.Limit > 0 {
+        fetch.Limit++
+    }
+
+    return &fetch
+}
+
+// 去掉多取的一行，返回是否还有数据
+
+func pageTrim(rows []common.SqlRes, query *common.DsQuery) ([]common.SqlRes, bool) {
+    if query.Limit > 0 && len(rows) > query.Limit {
+        return rows[:query.Limit], true
+    }
+
+    return rows, false
+}
+
+// 不需要额外统计即可确定的总行数：未分页，或首页即已取完全部数据
+
+func pageTotalKnown(rowNum int, hasMore bool, query *common.DsQuery) (int64, bool) {
+    if query.Cursor != "" {
+        return -1, false
+    }
+    if query.Limit == 0 || (query.Offset == 0 && !hasMore) {
+        return int64(query.Offset + rowNum), true
+    }
+
+    return -1, false
+}
+
+func dsPageFill(dsRes *common.DsResult, query *common.DsQuery, total int64, hasMore bool) {
+    dsRes.Total = total
+    dsRes.HasMore = hasMore
+    dsRes.Offset = query.Offset
+    dsRes.Limit = query.Limit
+}
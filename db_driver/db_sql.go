@@ -0,0 +1,670 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "gorm.io/gorm"
+    "strings"
+    "sync"
+    "time"
+)
+
+// SqlDriver 通用sql驱动，方言差异由Dialect处理
+
+type SqlDriver struct {
+    dbConn              *gorm.DB
+    datasourceInfo      common.DatasourceTable
+    dialect             Dialect
+    tableLock           sync.Mutex
+    tableSet            map[string]map[string]struct{}  // 数据库真实表名缓存，key为库/schema名(当前库为空)，用于校验db类型数据集
+}
+
+// DBConn 创建连接
+// 建立连接的同时根据结果更新数据库中source表对应状态信息
+
+func (s *SqlDriver) DBConn() error {
+    dialector, err := s.dialect.Open(s.datasourceInfo.Config)
+    if err != nil {
+        s.datasourceInfo.Status = ConnFail
+        return err
+    }
+
+    // 创建连接池
+    db, err := gorm.Open(dialector, &gorm.Config{})
+    if err != nil {
+        s.datasourceInfo.Status = ConnFail
+        return err
+    }
+
+    sqlDB, _ := db.DB()
+    sqlDB.SetMaxIdleConns(int(s.datasourceInfo.Config.MaxIdleTime))
+    sqlDB.SetMaxOpenConns(int(s.datasourceInfo.Config.MaxPoolSize))
+    sqlDB.SetConnMaxIdleTime(time.Duration(s.datasourceInfo.Config.ConnectTimeout) * time.Second)
+    s.dbConn = db
+    s.datasourceInfo.Status = ConnSuccess
+
+    return nil
+}
+
+// 查看驱动使用的方言
+
+func (s *SqlDriver) Dialect() Dialect {
+    return s.dialect
+}
+
+// 从数据库加载指定库/schema的真实表名，schema为空时为当前库
+
+func (s *SqlDriver) tableSetLoad(ctx context.Context, schema string) (map[string]struct{}, error) {
+    var tables []string
+    listSql, args := s.dialect.TableListSql(schema)
+    err := s.sqlScan(ctx, &tables, listSql, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    tableSet := make(map[string]struct{})
+    for _, table := range tables {
+        tableSet[table] = struct{}{}
+    }
+
+    return tableSet, nil
+}
+
+// 表名可能的写法：当前库中的表，或以第一个"."分隔的库/schema前缀加表名
+
+type tableName struct {
+    schema      string
+    table       string
+}
+
+func tableNameCandidates(name string) []tableName {
+    candidates := []tableName{{table: name}}
+    if index := strings.Index(name, "."); index > 0 && index < len(name) - 1 {
+        candidates = append(candidates, tableName{schema: name[:index], table: name[index+1:]})
+    }
+
+    return candidates
+}
+
+// db类型数据集的表名必须是数据库中真实存在的表，未命中缓存时重新加载一次表名
+// 带前缀的表名在对应库/schema的表中校验，返回转义后的表名
+// 表名查询在锁外执行，避免慢查询阻塞其它数据集的校验
+
+func (s *SqlDriver) tableCheck(ctx context.Context, name string) (string, error) {
+    candidates := tableNameCandidates(name)
+    s.tableLock.Lock()
+    for _, candidate := range candidates {
+        if _, ok := s.tableSet[candidate.schema][candidate.table]; ok {
+            s.tableLock.Unlock()
+            return s.tableQuote(candidate), nil
+        }
+    }
+    s.tableLock.Unlock()
+
+    for _, candidate := range candidates {
+        tableSet, err := s.tableSetLoad(ctx, candidate.schema)
+        if err != nil && candidate.schema != "" {
+            // 前缀不是库名时(如sqlite未attach的库)视为表不存在
+            return "", errors.New(fmt.Sprintf("table [%s] not exist in datasource [%s]: %v", name, s.datasourceInfo.Name, err))
+        }
+        if err != nil {
+            return "", err
+        }
+
+        s.tableLock.Lock()
+        if s.tableSet == nil {
+            s.tableSet = make(map[string]map[string]struct{})
+        }
+        s.tableSet[candidate.schema] = tableSet
+        s.tableLock.Unlock()
+
+        if _, ok := tableSet[candidate.table]; ok {
+            return s.tableQuote(candidate), nil
+        }
+    }
+
+    return "", errors.New(fmt.Sprintf("table [%s] not exist in datasource [%s]", name, s.datasourceInfo.Name))
+}
+
+func (s *SqlDriver) tableQuote(name tableName) string {
+    if name.schema == "" {
+        return s.dialect.QuoteIdent(name.table)
+    }
+
+    return s.dialect.QuoteIdent(name.schema) + "." + s.dialect.QuoteIdent(name.table)
+}
+
+// 根据db/sql类型确定查询的数据源部分
+// sql类型数据集中的变量替换为绑定参数，variables为nil时全部使用默认值
+// 数据集有计算字段时，数据源外包一层子查询，计算字段作为普通列输出
+
+func (s *SqlDriver) sqlFromBuild(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, variables map[string]interface{}) (*sqlQuery, error) {
+    from := &sqlQuery{}
+    switch di.Type {
+    case common.DatasetTypeDB:
+        if len(variables) > 0 {
+            return nil, errors.New(fmt.Sprintf("dataset type [%s] not support variables", di.Type))
+        }
+        table, err := s.tableCheck(ctx, di.Info)
+        if err != nil {
+            return nil, err
+        }
+        from.Write(table)
+    case common.DatasetTypeSQL:
+        sql, err := sqlVariableBind(s.dialect, di.Info, di.SqlVariableDetails, variables)
+        if err != nil {
+            return nil, err
+        }
+        from.Write(s.dialect.SubQuery(sql.String()), sql.Args()...)
+    default:
+        return nil, errors.New(fmt.Sprintf("dataset type [%s] not define", di.Type))
+    }
+
+    calc := &sqlQuery{}
+    for index, _ := range fields {
+        field := &fields[index]
+        if field.ExtField != 1 {
+            continue
+        }
+        node, err := exprParse(field.OriginName, fields)
+        if err != nil {
+            return nil, errors.New(fmt.Sprintf("calculated field [%s]: %v", field.Name, err))
+        }
+        calc.Write(", ")
+        exprCompile(node, s.dialect, calc)
+        calc.Write(" as " + s.dialect.QuoteIdent(field.Name))
+    }
+    if calc.String() == "" {
+        return from, nil
+    }
+
+    inner := &sqlQuery{}
+    inner.Write("select *").Append(calc).Write(" from ").Append(from)
+
+    return (&sqlQuery{}).Write(s.dialect.SubQuery(inner.String()), inner.Args()...), nil
+}
+
+// 字段对应的列，计算字段(OriginName为表达式)在数据源子查询中以字段名输出
+
+func (s *SqlDriver) sqlFieldColumn(field *common.DatasetTableField) string {
+    if field.ExtField == 1 {
+        return s.dialect.QuoteIdent(field.Name)
+    }
+
+    return s.dialect.QuoteIdent(field.OriginName)
+}
+
+// 字段在select列表中的写法，字段改名后以展示名作为别名输出
+
+func (s *SqlDriver) sqlFieldSelect(field *common.DatasetTableField) string {
+    column := s.sqlFieldColumn(field)
+    if field.ExtField == 1 || field.Name == field.OriginName {
+        return column
+    }
+
+    return fmt.Sprintf("%s as %s", column, s.dialect.QuoteIdent(field.Name))
+}
+
+// 原始数据查询的select列表：选中字段按列位置输出，排序键中未选中的字段追加在后，取数后再去掉
+
+func (s *SqlDriver) sqlColumnsBuild(fields []common.DatasetTableField, sorts []common.DsSort) (string, error) {
+    checked, err := checkedFieldsBuild(fields)
+    if err != nil {
+        return "", err
+    }
+
+    var columns []string
+    selected := make(map[string]struct{})
+    for index, _ := range checked {
+        columns = append(columns, s.sqlFieldSelect(&checked[index]))
+        selected[checked[index].Name] = struct{}{}
+    }
+
+    fieldMap := fieldNameMapBuild(fields)
+    for _, sort := range sorts {
+        field, ok := fieldMap[sort.Field]
+        if !ok {
+            continue
+        }
+        if _, ok := selected[field.Name]; !ok {
+            columns = append(columns, s.sqlFieldSelect(field))
+            selected[field.Name] = struct{}{}
+        }
+    }
+
+    return strings.Join(columns, ", "), nil
+}
+
+// 构建不含排序、分页的查询主体，用于数据查询以及总行数统计
+// keyset为游标分页条件，仅数据查询时给出
+
+func (s *SqlDriver) sqlBaseBuild(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery, keyset *sqlQuery) (*sqlQuery, error) {
+    granMap, err := timeGranBuild(fields, query)
+    if err != nil {
+        return nil, err
+    }
+
+    sql := &sqlQuery{}
+    if query.Aggregate {
+        if query.Compare != nil {
+            return s.sqlCompareBuild(ctx, di, fields, query, granMap)
+        }
+        project, parts, err := s.sqlAggregateSourceBuild(ctx, di, fields, query, granMap, nil)
+        if err != nil {
+            return nil, err
+        }
+
+        selects := append(append([]string{}, parts.dims...), parts.quotaSelects()...)
+        sql.Write(fmt.Sprintf("select %s from ", strings.Join(selects, ", "))).Write(s.dialect.SubQuery(project.String()), project.Args()...)
+        if len(parts.dims) > 0 {
+            sql.Write(fmt.Sprintf(" group by %s", strings.Join(parts.dims, ", ")))
+        }
+
+        return sql, nil
+    }
+
+    from, err := s.sqlFromBuild(ctx, di, fields, query.Variables)
+    if err != nil {
+        return nil, err
+    }
+
+    // 过滤条件编译为绑定参数
+    where, whereArgs, err := s.sqlFilterBuild(query.Filter, fields)
+    if err != nil {
+        return nil, err
+    }
+
+    columns, err := s.sqlColumnsBuild(fields, query.Sorts)
+    if err != nil {
+        return nil, err
+    }
+
+    sql.Write(fmt.Sprintf("select %s from ", columns)).Append(from)
+    conds := &sqlQuery{}
+    if where != "" {
+        conds.Write(where, whereArgs...)
+    }
+    if keyset != nil {
+        if where != "" {
+            conds.Write(" and ")
+        }
+        conds.Append(keyset)
+    }
+    if conds.String() != "" {
+        sql.Write(" where ").Append(conds)
+    }
+
+    return sql, nil
+}
+
+func (s *SqlDriver) sqlBuild(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery, keyset *sqlQuery) (*sqlQuery, error) {
+    sortSql, err := s.sqlSortBuild(query.Sorts, fields, query.Aggregate)
+    if err != nil {
+        return nil, err
+    }
+
+    sql, err := s.sqlBaseBuild(ctx, di, fields, query, keyset)
+    if err != nil {
+        return nil, err
+    }
+
+    if sortSql != "" {
+        sql.Write(fmt.Sprintf(" order by %s", sortSql))
+    }
+
+    // 仅在分页或limit字段有效时才构建
+    if !(query.Offset == 0 && query.Limit == 0) {
+        limitSql, limitArgs := s.dialect.LimitOffset(query.Limit, query.Offset)
+        sql.Write(" " + limitSql, limitArgs...)
+    }
+
+    return sql, nil
+}
+
+// 统计总行数，聚合查询统计分组数
+
+func (s *SqlDriver) sqlCount(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery) (int64, error) {
+    base, err := s.sqlBaseBuild(ctx, di, fields, query, nil)
+    if err != nil {
+        return 0, err
+    }
+
+    sql := &sqlQuery{}
+    sql.Write("select count(*) from ").Write(s.dialect.SubQuery(base.String()), base.Args()...)
+
+    var total int64
+    err = s.sqlScan(ctx, &total, sql.String(), sql.Args()...)
+    if err != nil {
+        return 0, err
+    }
+
+    return total, nil
+}
+
+// 下发语句，ctx中登记了执行中查询时上报终止方法并带上查询ID
+
+func (s *SqlDriver) sqlRaw(ctx context.Context, sql string, args ...interface{}) *gorm.DB {
+    queryId := runningQueryTrack(ctx, s.queryKill)
+    if queryId != "" {
+        ctx = s.dialect.QueryContext(ctx, queryId)
+    }
+
+    return s.dbConn.WithContext(ctx).Raw(sql, args...)
+}
+
+// 执行查询并扫描结果，查询在取到首行前被中止时gorm不返回错误，这里以ctx的状态补上
+
+func (s *SqlDriver) sqlScan(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
+    err := s.sqlRaw(ctx, sql, args...).Scan(dest).Error
+    if err == nil {
+        err = ctx.Err()
+    }
+
+    return err
+}
+
+// 在数据库服务端终止查询，方言不支持时返回nil
+
+func (s *SqlDriver) queryKill(queryId string) func() error {
+    killSql, args := s.dialect.KillQuery(queryId)
+    if killSql == "" {
+        return nil
+    }
+
+    return func() error {
+        if s.dbConn == nil {
+            return errors.New(fmt.Sprintf("datasource [%s] not connected", s.datasourceInfo.Name))
+        }
+        ctx, cancel := queryContext(context.Background(), s.datasourceInfo.Config)
+        defer cancel()
+
+        return s.dbConn.WithContext(ctx).Exec(killSql, args...).Error
+    }
+}
+
+// 执行查询，sql文本中不包含任何字面值，值全部通过绑定参数传递
+
+func (s *SqlDriver) sqlQueryExec(ctx context.Context, query *sqlQuery) ([]common.SqlRes, error) {
+    runningQuerySql(ctx, query.String())
+    var result []common.SqlRes
+    dbErr := s.sqlScan(ctx, &result, query.String(), query.Args()...)
+    if dbErr != nil {
+        return nil, dbErr
+    }
+
+    sqlResNormalize(result)
+
+    return result, nil
+}
+
+func (s *SqlDriver) sqlExec(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery, keyset *sqlQuery) ([]common.SqlRes, error) {
+    sql, err := s.sqlBuild(ctx, di, fields, query, keyset)
+    if err != nil {
+        return nil, err
+    }
+
+    // 执行sql
+    return s.sqlQueryExec(ctx, sql)
+}
+
+// 部分驱动(如sqlite表达式列)给出的ScanType为*interface{}，gorm扫描后map中保存的是指针，这里解引用
+
+func sqlResNormalize(result []common.SqlRes) {
+    for index, _ := range result {
+        for k, v := range result[index] {
+            if ptr, ok := v.(*interface{}); ok {
+                if ptr == nil {
+                    result[index][k] = nil
+                } else {
+                    result[index][k] = *ptr
+                }
+            }
+        }
+    }
+}
+
+// 根据sql执行结果，封装DsResult结构
+
+func (s *SqlDriver) GetData(ctx context.Context, datasetId string, di *common.DatasetTable, fields []common.DatasetTableField,
+    query *common.DsQuery) (*common.DsResult, error) {
+    ctx, cancel := queryContext(ctx, s.datasourceInfo.Config)
+    defer cancel()
+    if query == nil {
+        query = &common.DsQuery{}
+    }
+    if query.Pivot != nil {
+        return s.pivotGet(ctx, di, fields, query)
+    }
+    if query.TopN != nil {
+        return s.topNGet(ctx, di, fields, query)
+    }
+    if query.Compare != nil && !query.Aggregate {
+        aggregate := *query
+        aggregate.Aggregate = true
+        query = &aggregate
+    }
+
+    // 排序键追加唯一键，给出游标时从游标之后取数
+    sorts, keyset, err := pageSortsBuild(query, fields)
+    if err != nil {
+        return nil, err
+    }
+    fieldMap := fieldNameMapBuild(fields)
+    var keysetWhere *sqlQuery
+    if query.Cursor != "" {
+        if query.Offset != 0 || query.Limit <= 0 {
+            return nil, errors.New("cursor paging need offset 0 and limit > 0")
+        }
+        values, err := cursorDecode(query.Cursor, sorts, fieldMap)
+        if err != nil {
+            return nil, err
+        }
+        keysetWhere = keysetWhereBuild(sorts, values, fieldMap, func(field *common.DatasetTableField) string {
+            return s.sqlFieldColumn(field)
+        })
+    }
+
+    fetch := pageFetchQuery(query)
+    fetch.Sorts = sorts
+    sqlRes, err := s.sqlExec(ctx, di, fields, fetch, keysetWhere)
+    if err != nil {
+        return nil, err
+    }
+    sqlRes, hasMore := pageTrim(sqlRes, query)
+
+    total, ok := pageTotalKnown(len(sqlRes), hasMore, query)
+    if !ok && !query.SkipCount {
+        total, err = s.sqlCount(ctx, di, fields, query)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // 游标需要排序键的值，生成游标后再去掉未选中的列
+    // 排序键值无法编码时本页数据照常返回，仅不给出游标
+    cursor := ""
+    if keyset && hasMore {
+        cursor, err = cursorEncode(sorts, sqlRes[len(sqlRes) - 1], fieldMap)
+        if err != nil {
+            cursor = ""
+        }
+    }
+    var compareValues []map[string]interface{}
+    if query.Compare != nil {
+        compareValues = compareValuesTake(sqlRes)
+    }
+    checked, err := checkedFieldsBuild(fields)
+    if err != nil {
+        return nil, err
+    }
+    rowProject(sqlRes, checked)
+    granMap, err := timeGranBuild(fields, query)
+    if err != nil {
+        return nil, err
+    }
+
+    dsRes := dsResultBuild(sqlRes, checked, granMap)
+    dsPageFill(dsRes, query, total, hasMore)
+    dsRes.Cursor = cursor
+    if query.Compare != nil {
+        compareFill(dsRes, compareValues)
+    }
+
+    return dsRes, nil
+}
+
+func (s *SqlDriver) getFieldsBySQL(ctx context.Context, query *sqlQuery, datasetId string) ([]common.DatasetTableField, error) {
+    var datasetFields []common.DatasetTableField
+
+    rows, err := s.sqlRaw(ctx, query.String(), query.Args()...).Rows()
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    colTypes, err := rows.ColumnTypes()
+    if err != nil {
+        return nil, err
+    }
+
+    // 取样本行，用于推断无声明类型的列
+    sample := make([]interface{}, len(colTypes))
+    if rows.Next() {
+        samplePtr := make([]interface{}, len(colTypes))
+        for index, _ := range sample {
+            samplePtr[index] = &sample[index]
+        }
+        err = rows.Scan(samplePtr...)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    for index, _ := range colTypes {
+        col := colTypes[index]
+        size := int64(0)
+        size, _ = col.Length()
+
+        baseType := col.DatabaseTypeName()
+        dsType := s.dialect.DatasetType(baseType)
+        if dsType < 0 {
+            dsType = getDatasetTypeValue(sample[index])
+        }
+
+        datasetFields = append(datasetFields,
+            common.DatasetTableField{
+                FieldId: getDatasetFieldId(),
+                DatasetId: datasetId,
+                OriginName: col.Name(),
+                Name: col.Name(),
+                GroupType: common.FieldDimension,
+                Type: baseType,
+                Size: size,
+                DsType: dsType,
+                Checked: 1,
+                ColumnIndex: int64(index),
+            })
+    }
+
+    return datasetFields, nil
+}
+
+// 根据数据集信息获取所有field，sql变量使用默认值
+
+func (s *SqlDriver) GetDataFields(ctx context.Context, dsTable common.DatasetTable) ([]common.DatasetTableField, error) {
+    ctx, cancel := queryContext(ctx, s.datasourceInfo.Config)
+    defer cancel()
+    from, err := s.sqlFromBuild(ctx, &dsTable, nil, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    limitSql, limitArgs := s.dialect.LimitOffset(1, 0)
+    query := &sqlQuery{}
+    query.Write("select * from ").Append(from).Write(" " + limitSql, limitArgs...)
+
+    return s.getFieldsBySQL(ctx, query, dsTable.DatasetId)
+}
+
+// 查看数据记录的连接状态
+
+func (s *SqlDriver) GetDBConnStatus() DBConnStatus {
+    return s.datasourceInfo.Status
+}
+
+// CheckDBConnStatus 获取连接状态，ConnSuccess: 连接可用，ConnFail：连接不可用
+
+func (s *SqlDriver) CheckDBConnStatus() DBConnStatus {
+    if s.dbConn == nil {
+        // 重新建立连接
+        err := s.DBConn()
+        if err != nil {
+            return ConnFail
+        }
+
+        return ConnSuccess
+    }
+
+    sqlDB, _ := s.dbConn.DB()
+    ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.datasourceInfo.Config.ConnectTimeout) * time.Second)
+    defer cancel()
+
+    err := sqlDB.PingContext(ctx)
+    if err != nil {
+        s.datasourceInfo.Status = ConnFail
+        return ConnFail
+    }
+
+    return ConnSuccess
+}
+
+// DBRecovery 重新建立连接
+// 恢复连接的同时根据结果更新数据库中source表对应状态信息
+
+func (s *SqlDriver) DBRecovery() error {
+    _ = s.Close()
+
+    return s.DBConn()
+}
+
+// 删除连接
+
+func (s *SqlDriver) Close() error {
+    if s.dbConn == nil {
+        return nil
+    }
+
+    sqlDB, err := s.dbConn.DB()
+    if err == nil {
+        _ = sqlDB.Close()
+    }
+    s.dbConn = nil
+
+    return nil
+}
+
+func getDatasetFieldId() string {
+    return common.GetUUID()
+}
+
+// NewSqlDriver 根据方言创建sql驱动，外部模块可配合RegisterDriver接入新的sql引擎
+
+func NewSqlDriver(datasourceInfo common.DatasourceTable, dialect Dialect) (DBDriver, error) {
+    return newSqlDriver(datasourceInfo, dialect)
+}
+
+func newSqlDriver(datasourceInfo common.DatasourceTable, dialect Dialect) (*SqlDriver, error) {
+    if dialect == nil {
+        return nil, errors.New(fmt.Sprintf("datasource [%s] dialect is nil", datasourceInfo.Name))
+    }
+
+    source := &SqlDriver{datasourceInfo: datasourceInfo, dialect: dialect}
+    err := source.DBConn()
+    if err != nil {
+        return nil, err
+    }
+
+    return source, nil
+}
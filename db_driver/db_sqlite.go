@@ -0,0 +1,166 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "gorm.io/driver/sqlite"
+    "gorm.io/gorm"
+    "strings"
+)
+
+// SqliteDialect Config.DataBase为sqlite数据库文件路径，ExtraParams作为dsn的查询参数追加
+
+type SqliteDialect struct {
+}
+
+func (s SqliteDialect) Name() string {
+    return DatasourceSQLITE
+}
+
+func (s SqliteDialect) Open(config common.Configuration) (gorm.Dialector, error) {
+    if config.DataBase == "" {
+        return nil, errors.New("sqlite database path is empty")
+    }
+
+    // 封装dsn
+    dsn := config.DataBase
+    if config.ExtraParams != "" {
+        dsn = dsn + "?" + config.ExtraParams
+    }
+
+    return sqlite.Open(dsn), nil
+}
+
+func (s SqliteDialect) QuoteIdent(name string) string {
+    return quoteIdentWith(name, `"`)
+}
+
+func (s SqliteDialect) LimitOffset(limit, offset int) (string, []interface{}) {
+    return defaultLimitOffset(limit, offset)
+}
+
+func (s SqliteDialect) OrderBy(column string, order string, nulls string) string {
+    return defaultOrderBy(column, order, nulls)
+}
+
+func (s SqliteDialect) SubQuery(sql string) string {
+    return fmt.Sprintf("(%s) t", sql)
+}
+
+// sqlite按列声明类型的亲和性规则映射，声明类型可能带长度如varchar(20)
+// 表达式列没有声明类型，返回-1由驱动根据样本值推断
+
+func (s SqliteDialect) DatasetType(baseType string) int64 {
+    declType := strings.ToUpper(baseType)
+    if index := strings.Index(declType, "("); index >= 0 {
+        declType = declType[:index]
+    }
+    declType = strings.TrimSpace(declType)
+
+    switch {
+    case declType == "":
+        return -1
+    case strings.Contains(declType, "BOOL"), declType == "BIT":
+        return common.DSTypeBit
+    case strings.Contains(declType, "INT"):
+        return common.DSTypeInt
+    case strings.Contains(declType, "CHAR"), strings.Contains(declType, "CLOB"), strings.Contains(declType, "TEXT"):
+        return common.DSTypeVar
+    case strings.Contains(declType, "DATE"), strings.Contains(declType, "TIME"):
+        return common.DSTypeTime
+    case strings.Contains(declType, "REAL"), strings.Contains(declType, "FLOA"), strings.Contains(declType, "DOUB"),
+        strings.Contains(declType, "DEC"), strings.Contains(declType, "NUMERIC"):
+        return common.DSTypeDEC
+    default:
+        return common.DSTypeVar
+    }
+}
+
+func (s SqliteDialect) Func(name string, args ...string) string {
+    switch name {
+    case FuncConcat:
+        return "(" + strings.Join(args, " || ") + ")"
+    }
+
+    return defaultFunc(name, args...)
+}
+
+// 其他库(如attach的库)的表在该库的sqlite_master中，库名只能以标识符给出
+
+func (s SqliteDialect) TableListSql(schema string) (string, []interface{}) {
+    if schema == "" {
+        return "select name from sqlite_master where type in ('table', 'view')", nil
+    }
+
+    return fmt.Sprintf("select name from %s.sqlite_master where type in ('table', 'view')", s.QuoteIdent(schema)), nil
+}
+
+// sqlite以strftime截断时间，结果为文本
+
+func (s SqliteDialect) TimeBucket(column string, gran string) string {
+    switch gran {
+    case common.TimeGranMinute:
+        return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:%%M:00', %s)", column)
+    case common.TimeGranHour:
+        return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00:00', %s)", column)
+    case common.TimeGranWeek:
+        return fmt.Sprintf("date(%s, 'weekday 0', '-6 days')", column)
+    case common.TimeGranMonth:
+        return fmt.Sprintf("strftime('%%Y-%%m-01', %s)", column)
+    case common.TimeGranQuarter:
+        return fmt.Sprintf("printf('%%s-%%02d-01', strftime('%%Y', %s), (cast(strftime('%%m', %s) as integer) - 1) / 3 * 3 + 1)", column, column)
+    case common.TimeGranYear:
+        return fmt.Sprintf("strftime('%%Y-01-01', %s)", column)
+    }
+
+    return fmt.Sprintf("date(%s)", column)
+}
+
+// sqlite以datetime修饰符偏移时间，周、季度分别换算为天、月
+
+func (s SqliteDialect) TimeShift(column string, gran string, n int) string {
+    switch gran {
+    case common.TimeGranWeek:
+        return fmt.Sprintf("datetime(%s, '%+d days')", column, n * 7)
+    case common.TimeGranQuarter:
+        return fmt.Sprintf("datetime(%s, '%+d months')", column, n * 3)
+    }
+
+    return fmt.Sprintf("datetime(%s, '%+d %ss')", column, n, gran)
+}
+
+// sqlite的like没有默认转义符
+
+func (s SqliteDialect) LikeEscape() string {
+    return ` escape '\'`
+}
+
+func (s SqliteDialect) QueryContext(ctx context.Context, queryId string) context.Context {
+    return ctx
+}
+
+func (s SqliteDialect) KillQuery(queryId string) (string, []interface{}) {
+    return "", nil
+}
+
+// sqlite引号内没有反斜杠转义
+
+var sqliteLiteralStyle = sqlLiteralStyle{quotes: "'\"`"}
+
+func (s SqliteDialect) LiteralEnd(sql string, start int) int {
+    return sqlLiteralEnd(sql, start, sqliteLiteralStyle)
+}
+
+func (s SqliteDialect) PlaceholderEscape(text string) (string, error) {
+    return text, nil
+}
+
+func (s SqliteDialect) ApproxDistinct() bool {
+    return false
+}
+
+func NewSqliteDriver(datasourceInfo common.DatasourceTable) (DBDriver, error) {
+    return NewSqlDriver(datasourceInfo, SqliteDialect{})
+}
@@ -0,0 +1,127 @@
+package db_driver
+
+import (
+    "context"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "gorm.io/gorm"
+    "strings"
+    "time"
+)
+
+// Dialect sql方言，新的sql引擎只需实现方言即可复用通用sql驱动SqlDriver
+
+type Dialect interface {
+    Name() string                                                   // 方言名，与数据源类型一致
+    Open(config common.Configuration) (gorm.Dialector, error)       // 根据数据源配置创建gorm连接器
+    QuoteIdent(name string) string                                  // 标识符转义
+    LimitOffset(limit, offset int) (string, []interface{})          // 分页语法，limit/offset以绑定参数给出
+    OrderBy(column string, order string, nulls string) string       // 单个排序键写法，nulls为空/first/last
+    SubQuery(sql string) string                                     // sql类型数据集作为子查询时的写法
+    DatasetType(baseType string) int64                              // 原始字段类型映射为DSType，无法识别时返回-1
+    Func(name string, args ...string) string                        // 通用函数映射为方言函数
+    TableListSql(schema string) (string, []interface{})             // 查询指定库/schema(为空时为当前库)所有表(含视图)名的sql，结果仅一列
+    TimeBucket(column string, gran string) string                   // 时间截断到所在分桶的起点，粒度已校验
+    TimeShift(column string, gran string, n int) string             // 时间向后偏移n个粒度单位，粒度已校验
+    LikeEscape() string                                             // 使反斜杠成为like转义符需追加的子句，默认即为反斜杠时返回空
+    QueryContext(ctx context.Context, queryId string) context.Context  // 将查询ID带给数据库服务端，不支持时原样返回ctx
+    KillQuery(queryId string) (string, []interface{})               // 服务端终止查询的语句，不支持时返回空
+    LiteralEnd(sql string, start int) int                           // start处字符串、引号标识符或注释的结束位置，不是时返回start
+    PlaceholderEscape(text string) (string, error)                  // 处理用户sql片段中的?使驱动不将其当作占位符，无法处理时返回错误
+    ApproxDistinct() bool                                           // 是否支持近似去重计数，不支持时FuncApproxDistinct退化为精确去重
+}
+
+// 通用函数名，由各方言翻译为对应写法
+const (
+    FuncCount = "count"
+    FuncSum = "sum"
+    FuncAvg = "avg"
+    FuncMin = "min"
+    FuncMax = "max"
+    FuncCountDistinct = "count_distinct"
+    FuncApproxDistinct = "approx_distinct"     // 近似去重计数，不支持的方言退化为精确去重
+    FuncIf = "if"
+    FuncCoalesce = "coalesce"
+    FuncConcat = "concat"
+    FuncLength = "length"
+    FuncLower = "lower"
+    FuncUpper = "upper"
+    FuncAbs = "abs"
+    FuncRound = "round"
+)
+
+// 各方言通用的函数写法，方言只需处理差异部分
+
+func defaultFunc(name string, args ...string) string {
+    switch name {
+    case FuncCountDistinct, FuncApproxDistinct:
+        return fmt.Sprintf("count(distinct %s)", strings.Join(args, ", "))
+    case FuncIf:
+        if len(args) == 3 {
+            return fmt.Sprintf("(case when %s then %s else %s end)", args[0], args[1], args[2])
+        }
+    case FuncCount:
+        if len(args) == 0 {
+            return "count(*)"
+        }
+    }
+
+    return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+// 按包围字符转义标识符，标识符中的包围字符双写
+
+func quoteIdentWith(name string, quote string) string {
+    return quote + strings.ReplaceAll(name, quote, quote + quote) + quote
+}
+
+func defaultLimitOffset(limit, offset int) (string, []interface{}) {
+    return "limit ? offset ?", []interface{}{limit, offset}
+}
+
+// 无声明类型的列(如sqlite表达式列)根据样本值推断类型
+
+func getDatasetTypeValue(value interface{}) int64 {
+    switch value.(type) {
+    case int64, int32, int16, int8, int, uint64, uint32, uint16, uint8, uint:
+        return common.DSTypeInt
+    case float64, float32:
+        return common.DSTypeDEC
+    case time.Time:
+        return common.DSTypeTime
+    case bool:
+        return common.DSTypeBit
+    default:
+        return common.DSTypeVar
+    }
+}
+
+// 可识别的时间格式
+var timeTextLayouts = []string{
+    "2006-01-02 15:04:05",
+    "2006-01-02 15:04",
+    "2006-01-02",
+    "2006/01/02 15:04:05",
+    "2006/01/02 15:04",
+    "2006/01/02",
+    "2006/1/2 15:04:05",
+    "2006/1/2 15:04",
+    "2006/1/2",
+    time.RFC3339,
+    "2006-01-02T15:04:05",
+}
+
+const timeTextFormat = "2006-01-02 15:04:05"
+
+// 解析文本形式的时间
+
+func parseTimeText(value string) (time.Time, bool) {
+    for _, layout := range timeTextLayouts {
+        t, err := time.ParseInLocation(layout, value, time.Local)
+        if err == nil {
+            return t, true
+        }
+    }
+
+    return time.Time{}, false
+}
@@ -0,0 +1,149 @@
+package db_driver
+
+import (
+    "context"
+    chgo "github.com/ClickHouse/clickhouse-go"
+    "github.com/bingLAN/data_driver/common"
+    "reflect"
+    "testing"
+)
+
+// 各方言生成的sql片段，不需要连接数据库
+
+func TestDialectOrderBy(t *testing.T) {
+    cases := []struct {
+        dialect Dialect
+        order   string
+        nulls   string
+        want    string
+    }{
+        {MysqlDialect{}, "asc", "", "`a` asc"},
+        {MysqlDialect{}, "asc", common.SortNullsFirst, "`a` is null desc, `a` asc"},
+        {MysqlDialect{}, "desc", common.SortNullsLast, "`a` is null asc, `a` desc"},
+        {PostgresDialect{}, "desc", "", `"a" desc`},
+        {PostgresDialect{}, "asc", common.SortNullsLast, `"a" asc nulls last`},
+        {SqliteDialect{}, "desc", common.SortNullsFirst, `"a" desc nulls first`},
+        {ClickhouseDialect{}, "asc", common.SortNullsFirst, "`a` asc nulls first"},
+    }
+    for _, c := range cases {
+        got := c.dialect.OrderBy(c.dialect.QuoteIdent("a"), c.order, c.nulls)
+        if got != c.want {
+            t.Errorf("%s order by %s nulls [%s]: [%s], want [%s]", c.dialect.Name(), c.order, c.nulls, got, c.want)
+        }
+    }
+}
+
+func TestDialectQuoteIdent(t *testing.T) {
+    cases := []struct {
+        dialect Dialect
+        name    string
+        want    string
+    }{
+        {MysqlDialect{}, "a`b", "`a``b`"},
+        {PostgresDialect{}, `a"b`, `"a""b"`},
+        {SqliteDialect{}, `a"b`, `"a""b"`},
+        {ClickhouseDialect{}, "a`b\\", "`a\\`b\\\\`"},
+    }
+    for _, c := range cases {
+        got := c.dialect.QuoteIdent(c.name)
+        if got != c.want {
+            t.Errorf("%s quote [%s]: [%s], want [%s]", c.dialect.Name(), c.name, got, c.want)
+        }
+    }
+}
+
+func TestDialectTimeBucket(t *testing.T) {
+    cases := []struct {
+        dialect Dialect
+        gran    string
+        want    string
+    }{
+        {MysqlDialect{}, common.TimeGranDay, "date_format(t, '%Y-%m-%d')"},
+        {MysqlDialect{}, common.TimeGranWeek, "date_format(date_sub(t, interval weekday(t) day), '%Y-%m-%d')"},
+        {MysqlDialect{}, common.TimeGranQuarter, "concat(year(t), '-', lpad(quarter(t) * 3 - 2, 2, '0'), '-01')"},
+        {PostgresDialect{}, common.TimeGranMonth, "date_trunc('month', t)"},
+        {PostgresDialect{}, common.TimeGranQuarter, "date_trunc('quarter', t)"},
+        {SqliteDialect{}, common.TimeGranHour, "strftime('%Y-%m-%d %H:00:00', t)"},
+        {SqliteDialect{}, common.TimeGranWeek, "date(t, 'weekday 0', '-6 days')"},
+        {SqliteDialect{}, common.TimeGranQuarter, "printf('%s-%02d-01', strftime('%Y', t), (cast(strftime('%m', t) as integer) - 1) / 3 * 3 + 1)"},
+        {ClickhouseDialect{}, common.TimeGranMinute, "toStartOfMinute(t)"},
+        {ClickhouseDialect{}, common.TimeGranWeek, "toMonday(t)"},
+        {ClickhouseDialect{}, common.TimeGranYear, "toStartOfYear(t)"},
+    }
+    for _, c := range cases {
+        got := c.dialect.TimeBucket("t", c.gran)
+        if got != c.want {
+            t.Errorf("%s bucket %s: [%s], want [%s]", c.dialect.Name(), c.gran, got, c.want)
+        }
+    }
+}
+
+func TestDialectTimeShift(t *testing.T) {
+    cases := []struct {
+        dialect Dialect
+        gran    string
+        n       int
+        want    string
+    }{
+        {MysqlDialect{}, common.TimeGranMonth, 1, "date_add(t, interval 1 month)"},
+        {MysqlDialect{}, common.TimeGranQuarter, -1, "date_add(t, interval -1 quarter)"},
+        {PostgresDialect{}, common.TimeGranDay, 7, "(t + interval '7 day')"},
+        {PostgresDialect{}, common.TimeGranQuarter, 1, "(t + interval '3 month')"},
+        {SqliteDialect{}, common.TimeGranYear, 1, "datetime(t, '+1 years')"},
+        {SqliteDialect{}, common.TimeGranWeek, -2, "datetime(t, '-14 days')"},
+        {SqliteDialect{}, common.TimeGranQuarter, 1, "datetime(t, '+3 months')"},
+        {ClickhouseDialect{}, common.TimeGranHour, 1, "addHours(t, 1)"},
+        {ClickhouseDialect{}, common.TimeGranQuarter, -1, "addQuarters(t, -1)"},
+    }
+    for _, c := range cases {
+        got := c.dialect.TimeShift("t", c.gran, c.n)
+        if got != c.want {
+            t.Errorf("%s shift %d %s: [%s], want [%s]", c.dialect.Name(), c.n, c.gran, got, c.want)
+        }
+    }
+}
+
+func TestDialectFunc(t *testing.T) {
+    cases := []struct {
+        dialect Dialect
+        approx  bool
+    }{
+        {MysqlDialect{}, false},
+        {PostgresDialect{}, false},
+        {SqliteDialect{}, false},
+        {ClickhouseDialect{}, true},
+    }
+    for _, c := range cases {
+        if c.dialect.ApproxDistinct() != c.approx {
+            t.Errorf("%s approx distinct: %v, want %v", c.dialect.Name(), c.dialect.ApproxDistinct(), c.approx)
+        }
+        exact := c.dialect.Func(FuncCountDistinct, "a")
+        approx := c.dialect.Func(FuncApproxDistinct, "a")
+        if (exact != approx) != c.approx {
+            t.Errorf("%s approx distinct [%s], exact [%s]", c.dialect.Name(), approx, exact)
+        }
+    }
+}
+
+// clickhouse通过query_id在服务端终止查询，其他方言不支持
+
+func TestDialectKillQuery(t *testing.T) {
+    ctx := context.Background()
+    for _, d := range []Dialect{MysqlDialect{}, PostgresDialect{}, SqliteDialect{}} {
+        if d.QueryContext(ctx, "q1") != ctx {
+            t.Errorf("%s query context should be unchanged", d.Name())
+        }
+        if sql, args := d.KillQuery("q1"); sql != "" || args != nil {
+            t.Errorf("%s kill query: [%s] %v", d.Name(), sql, args)
+        }
+    }
+
+    c := ClickhouseDialect{}
+    if !reflect.DeepEqual(c.QueryContext(ctx, "q1"), chgo.WithQueryID(ctx, "q1")) {
+        t.Errorf("clickhouse query context should carry query id")
+    }
+    sql, args := c.KillQuery("q1")
+    if sql != "kill query where query_id = ? async" || !reflect.DeepEqual(args, []interface{}{"q1"}) {
+        t.Errorf("clickhouse kill query: [%s] %v", sql, args)
+    }
+}
@@ -0,0 +1,655 @@
+package db_driver
+
+import (
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// 计算字段表达式
+// 支持：数字、'字符串'、true/false/null，字段名(含特殊字符时写成[字段名])，
+// + - * / %，= != <> < <= > >=，and/or/not，括号以及函数
+// if/coalesce/concat/length/lower/upper/abs/round
+// 表达式在Go中完成解析与类型检查，再由方言编译成对应sql
+
+const (
+    exprTokenEOF = iota
+    exprTokenNumber
+    exprTokenString
+    exprTokenIdent
+    exprTokenField          // [字段名]
+    exprTokenOp
+)
+
+type exprToken struct {
+    kind    int
+    text    string
+    pos     int
+}
+
+const (
+    exprNodeLiteral = iota
+    exprNodeField
+    exprNodeUnary
+    exprNodeBinary
+    exprNodeFunc
+)
+
+// null字面量的类型，可与任意类型合并
+const exprTypeNull int64 = -1
+
+type exprNode struct {
+    kind    int
+    op      string          // 运算符或函数名
+    value   interface{}     // 字面量值，字符串以绑定参数输出
+    field   *common.DatasetTableField
+    args    []*exprNode
+    dsType  int64
+}
+
+// 各函数的参数个数范围，max为-1表示不限
+var exprFuncArgNum = map[string][2]int{
+    FuncIf: {3, 3},
+    FuncCoalesce: {1, -1},
+    FuncConcat: {1, -1},
+    FuncLength: {1, 1},
+    FuncLower: {1, 1},
+    FuncUpper: {1, 1},
+    FuncAbs: {1, 1},
+    FuncRound: {1, 2},
+}
+
+func exprLex(expr string) ([]exprToken, error) {
+    var tokens []exprToken
+    runes := []rune(expr)
+    for pos := 0; pos < len(runes); {
+        r := runes[pos]
+        switch {
+        case unicode.IsSpace(r):
+            pos++
+        case unicode.IsDigit(r) || (r == '.' && pos + 1 < len(runes) && unicode.IsDigit(runes[pos + 1])):
+            start := pos
+            for pos < len(runes) && (unicode.IsDigit(runes[pos]) || runes[pos] == '.') {
+                pos++
+            }
+            tokens = append(tokens, exprToken{kind: exprTokenNumber, text: string(runes[start:pos]), pos: start})
+        case r == '\'':
+            // 字符串内两个单引号表示一个单引号
+            start := pos
+            var sb strings.Builder
+            pos++
+            for {
+                if pos >= len(runes) {
+                    return nil, errors.New(fmt.Sprintf("expression string at %d not closed", start))
+                }
+                if runes[pos] == '\'' {
+                    if pos + 1 < len(runes) && runes[pos + 1] == '\'' {
+                        sb.WriteRune('\'')
+                        pos += 2
+                        continue
+                    }
+                    pos++
+                    break
+                }
+                sb.WriteRune(runes[pos])
+                pos++
+            }
+            tokens = append(tokens, exprToken{kind: exprTokenString, text: sb.String(), pos: start})
+        case r == '[':
+            start := pos
+            end := pos + 1
+            for end < len(runes) && runes[end] != ']' {
+                end++
+            }
+            if end >= len(runes) {
+                return nil, errors.New(fmt.Sprintf("expression field at %d not closed", start))
+            }
+            tokens = append(tokens, exprToken{kind: exprTokenField, text: string(runes[start + 1:end]), pos: start})
+            pos = end + 1
+        case unicode.IsLetter(r) || r == '_':
+            start := pos
+            for pos < len(runes) && (unicode.IsLetter(runes[pos]) || unicode.IsDigit(runes[pos]) || runes[pos] == '_') {
+                pos++
+            }
+            tokens = append(tokens, exprToken{kind: exprTokenIdent, text: string(runes[start:pos]), pos: start})
+        default:
+            start := pos
+            op := string(r)
+            if pos + 1 < len(runes) {
+                two := string(runes[pos:pos + 2])
+                if two == "<=" || two == ">=" || two == "!=" || two == "<>" {
+                    op = two
+                }
+            }
+            if !strings.Contains("+-*/%=<>!(),", string(r)) || op == "!" {
+                return nil, errors.New(fmt.Sprintf("expression character [%s] at %d not support", string(r), start))
+            }
+            pos += len([]rune(op))
+            tokens = append(tokens, exprToken{kind: exprTokenOp, text: op, pos: start})
+        }
+    }
+
+    return append(tokens, exprToken{kind: exprTokenEOF, pos: len(runes)}), nil
+}
+
+type exprParser struct {
+    tokens      []exprToken
+    pos         int
+    fieldMap    map[string]*common.DatasetTableField
+}
+
+func (p *exprParser) peek() exprToken {
+    return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+    token := p.tokens[p.pos]
+    if token.kind != exprTokenEOF {
+        p.pos++
+    }
+
+    return token
+}
+
+// 当前为指定运算符或关键字(不区分大小写)时前进
+
+func (p *exprParser) accept(kind int, text string) bool {
+    token := p.peek()
+    if token.kind == kind && strings.EqualFold(token.text, text) {
+        p.pos++
+        return true
+    }
+
+    return false
+}
+
+func (p *exprParser) expect(text string) error {
+    if !p.accept(exprTokenOp, text) {
+        token := p.peek()
+        return errors.New(fmt.Sprintf("expression expect [%s] at %d, got [%s]", text, token.pos, token.text))
+    }
+
+    return nil
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+    left, err := p.parseAnd()
+    if err != nil {
+        return nil, err
+    }
+    for p.accept(exprTokenIdent, "or") {
+        right, err := p.parseAnd()
+        if err != nil {
+            return nil, err
+        }
+        left = &exprNode{kind: exprNodeBinary, op: "or", args: []*exprNode{left, right}}
+    }
+
+    return left, nil
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+    left, err := p.parseNot()
+    if err != nil {
+        return nil, err
+    }
+    for p.accept(exprTokenIdent, "and") {
+        right, err := p.parseNot()
+        if err != nil {
+            return nil, err
+        }
+        left = &exprNode{kind: exprNodeBinary, op: "and", args: []*exprNode{left, right}}
+    }
+
+    return left, nil
+}
+
+func (p *exprParser) parseNot() (*exprNode, error) {
+    if p.accept(exprTokenIdent, "not") {
+        arg, err := p.parseNot()
+        if err != nil {
+            return nil, err
+        }
+        return &exprNode{kind: exprNodeUnary, op: "not", args: []*exprNode{arg}}, nil
+    }
+
+    return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (*exprNode, error) {
+    left, err := p.parseAdd()
+    if err != nil {
+        return nil, err
+    }
+    token := p.peek()
+    if token.kind == exprTokenOp {
+        switch token.text {
+        case "=", "!=", "<>", "<", "<=", ">", ">=":
+            p.next()
+            right, err := p.parseAdd()
+            if err != nil {
+                return nil, err
+            }
+            op := token.text
+            if op == "!=" {
+                op = "<>"
+            }
+            return &exprNode{kind: exprNodeBinary, op: op, args: []*exprNode{left, right}}, nil
+        }
+    }
+
+    return left, nil
+}
+
+func (p *exprParser) parseAdd() (*exprNode, error) {
+    left, err := p.parseMul()
+    if err != nil {
+        return nil, err
+    }
+    for {
+        token := p.peek()
+        if token.kind != exprTokenOp || (token.text != "+" && token.text != "-") {
+            return left, nil
+        }
+        p.next()
+        right, err := p.parseMul()
+        if err != nil {
+            return nil, err
+        }
+        left = &exprNode{kind: exprNodeBinary, op: token.text, args: []*exprNode{left, right}}
+    }
+}
+
+func (p *exprParser) parseMul() (*exprNode, error) {
+    left, err := p.parseUnary()
+    if err != nil {
+        return nil, err
+    }
+    for {
+        token := p.peek()
+        if token.kind != exprTokenOp || (token.text != "*" && token.text != "/" && token.text != "%") {
+            return left, nil
+        }
+        p.next()
+        right, err := p.parseUnary()
+        if err != nil {
+            return nil, err
+        }
+        left = &exprNode{kind: exprNodeBinary, op: token.text, args: []*exprNode{left, right}}
+    }
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+    if p.accept(exprTokenOp, "-") {
+        arg, err := p.parseUnary()
+        if err != nil {
+            return nil, err
+        }
+        return &exprNode{kind: exprNodeUnary, op: "-", args: []*exprNode{arg}}, nil
+    }
+
+    return p.parsePrimary()
+}
+
+func (p *exprParser) parseField(name string, pos int) (*exprNode, error) {
+    field, ok := p.fieldMap[name]
+    if !ok {
+        return nil, errors.New(fmt.Sprintf("expression field [%s] at %d not define in dataset", name, pos))
+    }
+    if field.ExtField == 1 {
+        return nil, errors.New(fmt.Sprintf("expression field [%s] at %d is a calculated field", name, pos))
+    }
+
+    return &exprNode{kind: exprNodeField, field: field}, nil
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+    token := p.next()
+    switch token.kind {
+    case exprTokenNumber:
+        if i, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+            return &exprNode{kind: exprNodeLiteral, value: i}, nil
+        }
+        f, err := strconv.ParseFloat(token.text, 64)
+        if err != nil {
+            return nil, errors.New(fmt.Sprintf("expression number [%s] at %d invalid", token.text, token.pos))
+        }
+        return &exprNode{kind: exprNodeLiteral, value: f}, nil
+    case exprTokenString:
+        return &exprNode{kind: exprNodeLiteral, value: token.text}, nil
+    case exprTokenField:
+        return p.parseField(token.text, token.pos)
+    case exprTokenIdent:
+        switch strings.ToLower(token.text) {
+        case "true":
+            return &exprNode{kind: exprNodeLiteral, value: true}, nil
+        case "false":
+            return &exprNode{kind: exprNodeLiteral, value: false}, nil
+        case "null":
+            return &exprNode{kind: exprNodeLiteral}, nil
+        }
+        if !p.accept(exprTokenOp, "(") {
+            return p.parseField(token.text, token.pos)
+        }
+
+        // 函数调用
+        name := strings.ToLower(token.text)
+        if _, ok := exprFuncArgNum[name]; !ok {
+            return nil, errors.New(fmt.Sprintf("expression function [%s] at %d not support", token.text, token.pos))
+        }
+        node := &exprNode{kind: exprNodeFunc, op: name}
+        if !p.accept(exprTokenOp, ")") {
+            for {
+                arg, err := p.parseOr()
+                if err != nil {
+                    return nil, err
+                }
+                node.args = append(node.args, arg)
+                if p.accept(exprTokenOp, ")") {
+                    break
+                }
+                if err = p.expect(","); err != nil {
+                    return nil, err
+                }
+            }
+        }
+        return node, nil
+    case exprTokenOp:
+        if token.text == "(" {
+            node, err := p.parseOr()
+            if err != nil {
+                return nil, err
+            }
+            if err = p.expect(")"); err != nil {
+                return nil, err
+            }
+            return node, nil
+        }
+    }
+
+    if token.kind == exprTokenEOF {
+        return nil, errors.New("expression unexpected end")
+    }
+
+    return nil, errors.New(fmt.Sprintf("expression unexpected [%s] at %d", token.text, token.pos))
+}
+
+// 合并两个值类型，用于if/coalesce的结果类型
+
+func exprTypeMerge(a int64, b int64) (int64, bool) {
+    switch {
+    case a == exprTypeNull:
+        return b, true
+    case b == exprTypeNull:
+        return a, true
+    case a == b:
+        return a, true
+    case isNumberType(a) && isNumberType(b):
+        return common.DSTypeDEC, true
+    }
+
+    return 0, false
+}
+
+// 比较运算两侧类型是否兼容，时间可与字符串比较
+
+func exprTypeComparable(a int64, b int64) bool {
+    if _, ok := exprTypeMerge(a, b); ok {
+        return true
+    }
+    if (a == common.DSTypeTime && b == common.DSTypeVar) || (a == common.DSTypeVar && b == common.DSTypeTime) {
+        return true
+    }
+    numberOrBit := func(t int64) bool {
+        return isNumberType(t) || t == common.DSTypeBit
+    }
+
+    return numberOrBit(a) && numberOrBit(b)
+}
+
+func exprTypeName(dsType int64) string {
+    switch dsType {
+    case common.DSTypeVar:
+        return "text"
+    case common.DSTypeTime:
+        return "time"
+    case common.DSTypeInt:
+        return "int"
+    case common.DSTypeDEC:
+        return "decimal"
+    case common.DSTypeBit:
+        return "bool"
+    }
+
+    return "null"
+}
+
+// 类型检查，确定每个节点的DSType
+
+func exprTypeCheck(node *exprNode) error {
+    for _, arg := range node.args {
+        if err := exprTypeCheck(arg); err != nil {
+            return err
+        }
+    }
+
+    switch node.kind {
+    case exprNodeLiteral:
+        switch node.value.(type) {
+        case int64:
+            node.dsType = common.DSTypeInt
+        case float64:
+            node.dsType = common.DSTypeDEC
+        case string:
+            node.dsType = common.DSTypeVar
+        case bool:
+            node.dsType = common.DSTypeBit
+        default:
+            node.dsType = exprTypeNull
+        }
+    case exprNodeField:
+        node.dsType = node.field.DsType
+    case exprNodeUnary:
+        argType := node.args[0].dsType
+        if node.op == "not" {
+            if argType != common.DSTypeBit && argType != exprTypeNull {
+                return errors.New(fmt.Sprintf("expression [not] need bool, got %s", exprTypeName(argType)))
+            }
+            node.dsType = common.DSTypeBit
+        } else {
+            if !isNumberType(argType) && argType != exprTypeNull {
+                return errors.New(fmt.Sprintf("expression [-] need number, got %s", exprTypeName(argType)))
+            }
+            node.dsType = argType
+        }
+    case exprNodeBinary:
+        left, right := node.args[0].dsType, node.args[1].dsType
+        switch node.op {
+        case "and", "or":
+            for _, t := range []int64{left, right} {
+                if t != common.DSTypeBit && t != exprTypeNull {
+                    return errors.New(fmt.Sprintf("expression [%s] need bool, got %s", node.op, exprTypeName(t)))
+                }
+            }
+            node.dsType = common.DSTypeBit
+        case "=", "<>", "<", "<=", ">", ">=":
+            if !exprTypeComparable(left, right) {
+                return errors.New(fmt.Sprintf("expression cannot compare %s with %s", exprTypeName(left), exprTypeName(right)))
+            }
+            node.dsType = common.DSTypeBit
+        default:
+            for _, t := range []int64{left, right} {
+                if !isNumberType(t) && t != exprTypeNull {
+                    return errors.New(fmt.Sprintf("expression [%s] need number, got %s", node.op, exprTypeName(t)))
+                }
+            }
+            switch {
+            case node.op == "/":
+                node.dsType = common.DSTypeDEC
+            case node.op == "%":
+                if left == common.DSTypeDEC || right == common.DSTypeDEC {
+                    return errors.New("expression [%] need integer")
+                }
+                node.dsType = common.DSTypeInt
+            default:
+                node.dsType, _ = exprTypeMerge(left, right)
+                if node.dsType == exprTypeNull {
+                    node.dsType = common.DSTypeInt
+                }
+            }
+        }
+    case exprNodeFunc:
+        argNum := exprFuncArgNum[node.op]
+        if len(node.args) < argNum[0] || (argNum[1] >= 0 && len(node.args) > argNum[1]) {
+            return errors.New(fmt.Sprintf("expression function [%s] args number [%d] invalid", node.op, len(node.args)))
+        }
+        return exprFuncTypeCheck(node)
+    }
+
+    return nil
+}
+
+func exprFuncTypeCheck(node *exprNode) error {
+    argType := node.args[0].dsType
+    switch node.op {
+    case FuncIf:
+        if argType != common.DSTypeBit && argType != exprTypeNull {
+            return errors.New(fmt.Sprintf("expression function [if] condition need bool, got %s", exprTypeName(argType)))
+        }
+        t, ok := exprTypeMerge(node.args[1].dsType, node.args[2].dsType)
+        if !ok {
+            return errors.New(fmt.Sprintf("expression function [if] branch type %s and %s not match",
+                exprTypeName(node.args[1].dsType), exprTypeName(node.args[2].dsType)))
+        }
+        node.dsType = t
+    case FuncCoalesce:
+        t := argType
+        for _, arg := range node.args[1:] {
+            merged, ok := exprTypeMerge(t, arg.dsType)
+            if !ok {
+                return errors.New(fmt.Sprintf("expression function [coalesce] args type %s and %s not match",
+                    exprTypeName(t), exprTypeName(arg.dsType)))
+            }
+            t = merged
+        }
+        node.dsType = t
+    case FuncConcat, FuncLower, FuncUpper:
+        node.dsType = common.DSTypeVar
+    case FuncLength:
+        node.dsType = common.DSTypeInt
+    case FuncAbs, FuncRound:
+        for _, arg := range node.args {
+            if !isNumberType(arg.dsType) && arg.dsType != exprTypeNull {
+                return errors.New(fmt.Sprintf("expression function [%s] need number, got %s", node.op, exprTypeName(arg.dsType)))
+            }
+        }
+        node.dsType = argType
+        if node.op == FuncRound {
+            node.dsType = common.DSTypeDEC
+        }
+    }
+    if node.dsType == exprTypeNull {
+        node.dsType = common.DSTypeVar
+    }
+
+    return nil
+}
+
+func exprParse(expr string, fields []common.DatasetTableField) (*exprNode, error) {
+    if strings.TrimSpace(expr) == "" {
+        return nil, errors.New("expression is empty")
+    }
+
+    tokens, err := exprLex(expr)
+    if err != nil {
+        return nil, err
+    }
+    parser := &exprParser{tokens: tokens, fieldMap: fieldNameMapBuild(fields)}
+    node, err := parser.parseOr()
+    if err != nil {
+        return nil, err
+    }
+    if token := parser.peek(); token.kind != exprTokenEOF {
+        return nil, errors.New(fmt.Sprintf("expression unexpected [%s] at %d", token.text, token.pos))
+    }
+
+    err = exprTypeCheck(node)
+    if err != nil {
+        return nil, err
+    }
+    if node.dsType == exprTypeNull {
+        return nil, errors.New("expression result cannot be null")
+    }
+
+    return node, nil
+}
+
+// ExprCheck 解析并检查计算字段表达式，返回结果类型(DSType)
+// 表达式只能引用数据集中的非计算字段
+
+func ExprCheck(expr string, fields []common.DatasetTableField) (int64, error) {
+    node, err := exprParse(expr, fields)
+    if err != nil {
+        return 0, err
+    }
+
+    return node.dsType, nil
+}
+
+// 将表达式编译为方言sql，字符串字面量以绑定参数输出，数字字面量已在解析时校验
+
+func exprCompile(node *exprNode, dialect Dialect, query *sqlQuery) {
+    switch node.kind {
+    case exprNodeLiteral:
+        switch v := node.value.(type) {
+        case int64:
+            query.Write(strconv.FormatInt(v, 10))
+        case float64:
+            query.Write(strconv.FormatFloat(v, 'f', -1, 64))
+        case string:
+            query.Write("?", v)
+        case bool:
+            if v {
+                query.Write("(1 = 1)")
+            } else {
+                query.Write("(1 = 0)")
+            }
+        default:
+            query.Write("null")
+        }
+    case exprNodeField:
+        query.Write(dialect.QuoteIdent(node.field.OriginName))
+    case exprNodeUnary:
+        if node.op == "not" {
+            query.Write("(not ")
+        } else {
+            query.Write("(-")
+        }
+        exprCompile(node.args[0], dialect, query)
+        query.Write(")")
+    case exprNodeBinary:
+        query.Write("(")
+        exprCompile(node.args[0], dialect, query)
+        if node.op == "/" {
+            // 避免整数相除被截断
+            query.Write(" * 1.0")
+        }
+        query.Write(" " + node.op + " ")
+        exprCompile(node.args[1], dialect, query)
+        query.Write(")")
+    case exprNodeFunc:
+        // 参数先各自编译，再交给方言组装，参数中的绑定值按顺序拼接
+        var args []string
+        var argValues []interface{}
+        for _, arg := range node.args {
+            argQuery := &sqlQuery{}
+            exprCompile(arg, dialect, argQuery)
+            args = append(args, argQuery.String())
+            argValues = append(argValues, argQuery.Args()...)
+        }
+        query.Write(dialect.Func(node.op, args...), argValues...)
+    }
+}
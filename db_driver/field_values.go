@@ -0,0 +1,124 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+)
+
+// 字段取值默认返回的个数
+const fieldValuesDefaultLimit = 100
+
+// 字段取值查询结果的列名，避免与字段同名时被别名替换(如clickhouse)
+const (
+    fieldValueColumn = "__value"
+    fieldCountColumn = "__count"
+)
+
+// 转义like中的通配符，转义符为反斜杠
+
+func likeEscape(value string) string {
+    value = strings.ReplaceAll(value, `\`, `\\`)
+    value = strings.ReplaceAll(value, "%", `\%`)
+    return strings.ReplaceAll(value, "_", `\_`)
+}
+
+// 字段取值：按字段分组统计出现次数，按次数降序、取值升序返回前limit个
+// search不为空时只在文本字段上按前缀或包含匹配
+
+func (s *SqlDriver) GetFieldValues(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, fieldName string, search string, limit int,
+    options *common.DsValueOptions) ([]common.DsFieldValue, error) {
+    ctx, cancel := queryContext(ctx, s.datasourceInfo.Config)
+    defer cancel()
+    if options == nil {
+        options = &common.DsValueOptions{}
+    }
+    if limit <= 0 {
+        limit = fieldValuesDefaultLimit
+    }
+
+    fieldMap := fieldNameMapBuild(fields)
+    field, ok := fieldMap[fieldName]
+    if !ok {
+        return nil, errors.New(fmt.Sprintf("field [%s] not define in dataset", fieldName))
+    }
+    col := s.sqlFieldColumn(field)
+
+    from, err := s.sqlFromBuild(ctx, di, fields, options.Variables)
+    if err != nil {
+        return nil, err
+    }
+    where, whereArgs, err := s.sqlFilterBuild(options.Filter, fields)
+    if err != nil {
+        return nil, err
+    }
+
+    conds := &sqlQuery{}
+    if where != "" {
+        conds.Write(where, whereArgs...)
+    }
+    if search != "" {
+        if field.DsType != common.DSTypeVar {
+            return nil, errors.New(fmt.Sprintf("field [%s] is not text field, cannot search", fieldName))
+        }
+        var pattern string
+        switch options.Match {
+        case "", common.ValueMatchPrefix:
+            pattern = likeEscape(search) + "%"
+        case common.ValueMatchContains:
+            pattern = "%" + likeEscape(search) + "%"
+        default:
+            return nil, errors.New(fmt.Sprintf("value match [%s] not support", options.Match))
+        }
+        if where != "" {
+            conds.Write(" and ")
+        }
+        conds.Write(fmt.Sprintf("%s like ?%s", col, s.dialect.LikeEscape()), pattern)
+    }
+
+    sqlRes, err := s.sqlQueryExec(ctx, s.fieldValuesSql(from, field, conds, limit))
+    if err != nil {
+        return nil, err
+    }
+
+    return fieldValuesResult(sqlRes, field), nil
+}
+
+// 字段取值的分组查询，conds为空时不加条件
+
+func (s *SqlDriver) fieldValuesSql(from *sqlQuery, field *common.DatasetTableField, conds *sqlQuery, limit int) *sqlQuery {
+    col := s.sqlFieldColumn(field)
+    value := s.dialect.QuoteIdent(fieldValueColumn)
+    count := s.dialect.QuoteIdent(fieldCountColumn)
+    sql := &sqlQuery{}
+    sql.Write(fmt.Sprintf("select %s as %s, %s as %s from ", col, value, s.dialect.Func(FuncCount), count)).Append(from)
+    if conds != nil && conds.String() != "" {
+        sql.Write(" where ").Append(conds)
+    }
+    sql.Write(fmt.Sprintf(" group by %s order by %s desc, %s ", col, count, s.dialect.OrderBy(value, common.SortAsc, "")))
+    limitSql, limitArgs := s.dialect.LimitOffset(limit, 0)
+    sql.Write(limitSql, limitArgs...)
+
+    return sql
+}
+
+func fieldValuesResult(sqlRes []common.SqlRes, field *common.DatasetTableField) []common.DsFieldValue {
+    def := FieldDef{Name: field.Name}
+    if field.DsType == common.DSTypeTime {
+        def.TimeFormat = timeLabelPattern(field, "")
+    }
+    values := make([]common.DsFieldValue, 0, len(sqlRes))
+    for index, _ := range sqlRes {
+        v := sqlRes[index][fieldValueColumn]
+        n, _ := resultNumber(sqlRes[index][fieldCountColumn])
+        values = append(values, common.DsFieldValue{
+            Value: v,
+            Label: dimensionLabel(v, def),
+            Count: int64(n),
+        })
+    }
+
+    return values
+}
@@ -0,0 +1,302 @@
+package db_driver
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// 各操作符需要的value个数，-1表示至少一个
+var filterOpValueNum = map[string]int{
+    common.FilterOpEq: 1,
+    common.FilterOpNe: 1,
+    common.FilterOpGt: 1,
+    common.FilterOpGe: 1,
+    common.FilterOpLt: 1,
+    common.FilterOpLe: 1,
+    common.FilterOpIn: -1,
+    common.FilterOpNotIn: -1,
+    common.FilterOpBetween: 2,
+    common.FilterOpLike: 1,
+    common.FilterOpIsNull: 0,
+    common.FilterOpNotNull: 0,
+}
+
+var filterOpSql = map[string]string{
+    common.FilterOpEq: "=",
+    common.FilterOpNe: "<>",
+    common.FilterOpGt: ">",
+    common.FilterOpGe: ">=",
+    common.FilterOpLt: "<",
+    common.FilterOpLe: "<=",
+}
+
+func fieldNameMapBuild(fields []common.DatasetTableField) map[string]*common.DatasetTableField {
+    fieldMap := make(map[string]*common.DatasetTableField)
+    for index, _ := range fields {
+        fieldMap[fields[index].Name] = &fields[index]
+    }
+
+    return fieldMap
+}
+
+func filterNumber(value interface{}) (float64, bool) {
+    switch v := value.(type) {
+    case int:
+        return float64(v), true
+    case int8:
+        return float64(v), true
+    case int16:
+        return float64(v), true
+    case int32:
+        return float64(v), true
+    case int64:
+        return float64(v), true
+    case uint:
+        return float64(v), true
+    case uint8:
+        return float64(v), true
+    case uint16:
+        return float64(v), true
+    case uint32:
+        return float64(v), true
+    case uint64:
+        return float64(v), true
+    case float32:
+        return float64(v), true
+    case float64:
+        return v, true
+    case json.Number:
+        f, err := v.Float64()
+        return f, err == nil
+    }
+
+    return 0, false
+}
+
+// 将过滤值转换为字段类型对应的绑定值
+
+func filterValueConvert(value interface{}, field *common.DatasetTableField) (interface{}, error) {
+    if value == nil {
+        return nil, errors.New(fmt.Sprintf("filter field [%s] value is null, use is_null instead", field.Name))
+    }
+
+    switch field.DsType {
+    case common.DSTypeInt:
+        if s, ok := value.(string); ok {
+            i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+            if err != nil {
+                return nil, errors.New(fmt.Sprintf("filter field [%s] value [%s] is not integer", field.Name, s))
+            }
+            return i, nil
+        }
+        if i, ok := value.(int64); ok {
+            return i, nil
+        }
+        if n, ok := value.(json.Number); ok {
+            // 大整数避免经float64丢失精度
+            if i, err := n.Int64(); err == nil {
+                return i, nil
+            }
+        }
+        f, ok := filterNumber(value)
+        if !ok || f != math.Trunc(f) {
+            return nil, errors.New(fmt.Sprintf("filter field [%s] value [%v] is not integer", field.Name, value))
+        }
+        return int64(f), nil
+    case common.DSTypeDEC:
+        if s, ok := value.(string); ok {
+            f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+            if err != nil {
+                return nil, errors.New(fmt.Sprintf("filter field [%s] value [%s] is not number", field.Name, s))
+            }
+            return f, nil
+        }
+        f, ok := filterNumber(value)
+        if !ok {
+            return nil, errors.New(fmt.Sprintf("filter field [%s] value [%v] is not number", field.Name, value))
+        }
+        return f, nil
+    case common.DSTypeTime:
+        switch v := value.(type) {
+        case time.Time:
+            return v.Format(timeTextFormat), nil
+        case string:
+            t, ok := parseTimeText(strings.TrimSpace(v))
+            if !ok {
+                return nil, errors.New(fmt.Sprintf("filter field [%s] value [%s] is not time", field.Name, v))
+            }
+            return t.Format(timeTextFormat), nil
+        }
+        return nil, errors.New(fmt.Sprintf("filter field [%s] value [%v] is not time", field.Name, value))
+    case common.DSTypeBit:
+        if b, ok := value.(bool); ok {
+            if b {
+                return int64(1), nil
+            }
+            return int64(0), nil
+        }
+        f, ok := filterNumber(value)
+        if !ok || (f != 0 && f != 1) {
+            return nil, errors.New(fmt.Sprintf("filter field [%s] value [%v] is not bit", field.Name, value))
+        }
+        return int64(f), nil
+    default:
+        switch v := value.(type) {
+        case string:
+            return v, nil
+        case map[string]interface{}, []interface{}:
+            return nil, errors.New(fmt.Sprintf("filter field [%s] value [%v] is not scalar", field.Name, value))
+        }
+        return fmt.Sprintf("%v", value), nil
+    }
+}
+
+// 校验过滤条件树，返回Values已转换为绑定值的副本
+
+func filterCheck(filter *common.DsFilter, fields []common.DatasetTableField) (*common.DsFilter, error) {
+    if filter.IsEmpty() {
+        return nil, nil
+    }
+
+    return filterNodeCheck(filter, fieldNameMapBuild(fields))
+}
+
+func filterNodeCheck(filter *common.DsFilter, fieldMap map[string]*common.DatasetTableField) (*common.DsFilter, error) {
+    if filter.IsGroup() {
+        logic := strings.ToLower(filter.Logic)
+        if logic == "" {
+            logic = common.FilterLogicAnd
+        }
+        if logic != common.FilterLogicAnd && logic != common.FilterLogicOr {
+            return nil, errors.New(fmt.Sprintf("filter logic [%s] not support", filter.Logic))
+        }
+
+        node := &common.DsFilter{Logic: logic}
+        for index, _ := range filter.Children {
+            if filter.Children[index].IsEmpty() {
+                continue
+            }
+            child, err := filterNodeCheck(&filter.Children[index], fieldMap)
+            if err != nil {
+                return nil, err
+            }
+            node.Children = append(node.Children, *child)
+        }
+
+        return node, nil
+    }
+
+    field, ok := fieldMap[filter.Field]
+    if !ok {
+        return nil, errors.New(fmt.Sprintf("filter field [%s] not define in dataset", filter.Field))
+    }
+    op := strings.ToLower(filter.Op)
+    num, ok := filterOpValueNum[op]
+    if !ok {
+        return nil, errors.New(fmt.Sprintf("filter op [%s] not support", filter.Op))
+    }
+    if (num >= 0 && len(filter.Values) != num) || (num < 0 && len(filter.Values) == 0) {
+        return nil, errors.New(fmt.Sprintf("filter field [%s] op [%s] values number [%d] invalid", filter.Field, op, len(filter.Values)))
+    }
+
+    node := &common.DsFilter{Field: filter.Field, Op: op}
+    for _, value := range filter.Values {
+        var bindValue interface{}
+        var err error
+        if op == common.FilterOpLike {
+            // like的匹配串总是文本
+            s, ok := value.(string)
+            if !ok {
+                return nil, errors.New(fmt.Sprintf("filter field [%s] like value [%v] is not string", filter.Field, value))
+            }
+            bindValue = s
+        } else {
+            bindValue, err = filterValueConvert(value, field)
+            if err != nil {
+                return nil, err
+            }
+        }
+        node.Values = append(node.Values, bindValue)
+    }
+
+    return node, nil
+}
+
+// 将校验后过滤条件中的字段名替换为原始字段名，用于下发给按原始字段解释条件的后端
+
+func filterOriginRename(filter *common.DsFilter, fieldMap map[string]*common.DatasetTableField) {
+    if filter.IsGroup() {
+        for index, _ := range filter.Children {
+            filterOriginRename(&filter.Children[index], fieldMap)
+        }
+        return
+    }
+
+    filter.Field = fieldMap[filter.Field].OriginName
+}
+
+// 将校验后的过滤条件编译为where子句，值全部以绑定参数传递
+// column根据字段给出该字段在sql中的写法
+
+func filterSqlBuild(filter *common.DsFilter, fieldMap map[string]*common.DatasetTableField,
+    column func(field *common.DatasetTableField) string) (string, []interface{}) {
+    if filter.IsGroup() {
+        var parts []string
+        var args []interface{}
+        for index, _ := range filter.Children {
+            part, partArgs := filterSqlBuild(&filter.Children[index], fieldMap, column)
+            if part == "" {
+                continue
+            }
+            parts = append(parts, part)
+            args = append(args, partArgs...)
+        }
+        if len(parts) == 0 {
+            return "", nil
+        }
+
+        return "(" + strings.Join(parts, " " + filter.Logic + " ") + ")", args
+    }
+
+    col := column(fieldMap[filter.Field])
+    switch filter.Op {
+    case common.FilterOpIn, common.FilterOpNotIn:
+        op := "in"
+        if filter.Op == common.FilterOpNotIn {
+            op = "not in"
+        }
+        holders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.Values)), ", ")
+        return fmt.Sprintf("%s %s (%s)", col, op, holders), filter.Values
+    case common.FilterOpBetween:
+        return fmt.Sprintf("%s between ? and ?", col), filter.Values
+    case common.FilterOpLike:
+        return fmt.Sprintf("%s like ?", col), filter.Values
+    case common.FilterOpIsNull:
+        return fmt.Sprintf("%s is null", col), nil
+    case common.FilterOpNotNull:
+        return fmt.Sprintf("%s is not null", col), nil
+    default:
+        return fmt.Sprintf("%s %s ?", col, filterOpSql[filter.Op]), filter.Values
+    }
+}
+
+// 校验并编译过滤条件
+
+func (s *SqlDriver) sqlFilterBuild(filter *common.DsFilter, fields []common.DatasetTableField) (string, []interface{}, error) {
+    checked, err := filterCheck(filter, fields)
+    if err != nil || checked == nil {
+        return "", nil, err
+    }
+
+    where, args := filterSqlBuild(checked, fieldNameMapBuild(fields), func(field *common.DatasetTableField) string {
+        return s.sqlFieldColumn(field)
+    })
+
+    return where, args, nil
+}
@@ -0,0 +1,99 @@
+package db_driver
+
+import (
+    "encoding/json"
+    "github.com/bingLAN/data_driver/common"
+    "reflect"
+    "testing"
+)
+
+// 测试用的数据集字段：city改名为城市且未选中，total为计算字段
+
+func testFieldsBuild() []common.DatasetTableField {
+    return []common.DatasetTableField{
+        {Name: "province", OriginName: "province", DsType: common.DSTypeVar, Checked: 1},
+        {Name: "城市", OriginName: "city", DsType: common.DSTypeVar},
+        {Name: "ul_bytes", OriginName: "ul_bytes", DsType: common.DSTypeInt, Checked: 1, UniqueKey: 1},
+        {Name: "rate", OriginName: "rate", DsType: common.DSTypeDEC, Checked: 1},
+        {Name: "ts", OriginName: "ts", DsType: common.DSTypeTime, Checked: 1},
+        {Name: "total", OriginName: "ul_bytes + dl_bytes", DsType: common.DSTypeInt, Checked: 1, ExtField: 1},
+    }
+}
+
+// 过滤条件编译为where子句，值全部以绑定参数传递并转换为字段类型
+
+func TestSqlFilterBuild(t *testing.T) {
+    s := &SqlDriver{dialect: SqliteDialect{}}
+    fields := testFieldsBuild()
+    cases := []struct {
+        name    string
+        filter  *common.DsFilter
+        want    string
+        args    []interface{}
+    }{
+        {"empty", &common.DsFilter{}, "", nil},
+        {"injection", &common.DsFilter{Field: "province", Op: "eq", Values: []interface{}{"x' or '1'='1"}},
+            `"province" = ?`, []interface{}{"x' or '1'='1"}},
+        {"upper op", &common.DsFilter{Field: "ul_bytes", Op: "NE", Values: []interface{}{"700"}},
+            `"ul_bytes" <> ?`, []interface{}{int64(700)}},
+        {"in", &common.DsFilter{Field: "province", Op: "in", Values: []interface{}{"北京市", "上海市"}},
+            `"province" in (?, ?)`, []interface{}{"北京市", "上海市"}},
+        {"not in", &common.DsFilter{Field: "ul_bytes", Op: "not_in", Values: []interface{}{1}},
+            `"ul_bytes" not in (?)`, []interface{}{int64(1)}},
+        {"between", &common.DsFilter{Field: "ul_bytes", Op: "between", Values: []interface{}{200, "700"}},
+            `"ul_bytes" between ? and ?`, []interface{}{int64(200), int64(700)}},
+        {"number", &common.DsFilter{Field: "rate", Op: "gt", Values: []interface{}{json.Number("3.5")}},
+            `"rate" > ?`, []interface{}{3.5}},
+        {"time", &common.DsFilter{Field: "ts", Op: "ge", Values: []interface{}{"2023/5/2"}},
+            `"ts" >= ?`, []interface{}{"2023-05-02 00:00:00"}},
+        {"rename", &common.DsFilter{Field: "城市", Op: "like", Values: []interface{}{"%州"}},
+            `"city" like ?`, []interface{}{"%州"}},
+        {"calc field", &common.DsFilter{Field: "total", Op: "le", Values: []interface{}{700.0}},
+            `"total" <= ?`, []interface{}{int64(700)}},
+        {"is null", &common.DsFilter{Field: "rate", Op: "is_null"}, `"rate" is null`, nil},
+        {"group", &common.DsFilter{
+            Logic: "OR",
+            Children: []common.DsFilter{
+                {Field: "province", Op: "eq", Values: []interface{}{"上海市"}},
+                {},
+                {
+                    Children: []common.DsFilter{
+                        {Field: "province", Op: "eq", Values: []interface{}{"广东省"}},
+                        {Field: "rate", Op: "not_null"},
+                    },
+                },
+            },
+        }, `("province" = ? or ("province" = ? and "rate" is not null))`, []interface{}{"上海市", "广东省"}},
+    }
+    for _, c := range cases {
+        where, args, err := s.sqlFilterBuild(c.filter, fields)
+        if err != nil {
+            t.Errorf("%s: %v", c.name, err)
+            continue
+        }
+        if where != c.want || !reflect.DeepEqual(args, c.args) {
+            t.Errorf("%s: [%s] %v, want [%s] %v", c.name, where, args, c.want, c.args)
+        }
+    }
+
+    // 字段未定义、值类型不匹配、值个数不匹配、操作符以及逻辑不支持
+    invalid := []*common.DsFilter{
+        {Field: "unknown", Op: "eq", Values: []interface{}{1}},
+        {Field: "city", Op: "eq", Values: []interface{}{"广州"}},
+        {Field: "ul_bytes", Op: "eq", Values: []interface{}{"1 or 1=1"}},
+        {Field: "ul_bytes", Op: "eq", Values: []interface{}{1.5}},
+        {Field: "ul_bytes", Op: "between", Values: []interface{}{1}},
+        {Field: "ul_bytes", Op: "in"},
+        {Field: "ts", Op: "gt", Values: []interface{}{"yesterday"}},
+        {Field: "province", Op: "eq", Values: []interface{}{nil}},
+        {Field: "province", Op: "like", Values: []interface{}{1}},
+        {Field: "province", Op: "regexp", Values: []interface{}{"x"}},
+        {Logic: "xor", Children: []common.DsFilter{{Field: "rate", Op: "is_null"}}},
+    }
+    for _, filter := range invalid {
+        _, _, err := s.sqlFilterBuild(filter, fields)
+        if err == nil {
+            t.Errorf("filter %+v should fail", *filter)
+        }
+    }
+}
@@ -0,0 +1,309 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+)
+
+// 按透视角色重设字段：行、列维度作为选中的维度，指标作为选中的指标，其他字段不输出
+// 指标为空时取数据集中选中的指标字段，返回字段副本以及指标字段名
+
+func pivotFieldsBuild(pivot *common.DsPivot, fields []common.DatasetTableField) ([]common.DatasetTableField, []string, error) {
+    fieldMap := fieldNameMapBuild(fields)
+    roleMap := make(map[string]string)
+    for _, name := range append(append([]string{}, pivot.Rows...), pivot.Columns...) {
+        if _, ok := fieldMap[name]; !ok {
+            return nil, nil, errors.New(fmt.Sprintf("pivot field [%s] not define in dataset", name))
+        }
+        if _, ok := roleMap[name]; ok {
+            return nil, nil, errors.New(fmt.Sprintf("pivot field [%s] duplicate", name))
+        }
+        roleMap[name] = common.FieldDimension
+    }
+
+    measures := pivot.Measures
+    if len(measures) == 0 {
+        checked, err := checkedFieldsBuild(fields)
+        if err != nil {
+            return nil, nil, err
+        }
+        for index, _ := range checked {
+            if checked[index].GroupType == common.FieldQuota {
+                if _, ok := roleMap[checked[index].Name]; !ok {
+                    measures = append(measures, checked[index].Name)
+                }
+            }
+        }
+    }
+    if len(measures) == 0 {
+        return nil, nil, errors.New("pivot query need measure field")
+    }
+    for _, name := range measures {
+        if _, ok := fieldMap[name]; !ok {
+            return nil, nil, errors.New(fmt.Sprintf("pivot measure [%s] not define in dataset", name))
+        }
+        if _, ok := roleMap[name]; ok {
+            return nil, nil, errors.New(fmt.Sprintf("pivot field [%s] duplicate", name))
+        }
+        roleMap[name] = common.FieldQuota
+    }
+
+    pivotFields := make([]common.DatasetTableField, len(fields))
+    copy(pivotFields, fields)
+    for index, _ := range pivotFields {
+        role, ok := roleMap[pivotFields[index].Name]
+        if !ok {
+            pivotFields[index].Checked = 0
+            continue
+        }
+        pivotFields[index].Checked = 1
+        pivotFields[index].GroupType = role
+    }
+
+    return pivotFields, measures, nil
+}
+
+// 需要查询的维度层级，从明细到总计，dimNum为0时只有总计
+
+func pivotLevels(dimNum int, subtotal bool) []int {
+    levels := []int{dimNum}
+    if subtotal {
+        for level := dimNum - 1; level >= 1; level-- {
+            levels = append(levels, level)
+        }
+    }
+    if dimNum > 0 {
+        levels = append(levels, 0)
+    }
+
+    return levels
+}
+
+// 行/列维度取值组合，values为原始取值，labels为展示文本
+
+type pivotTuple struct {
+    values      []interface{}
+    labels      []string
+}
+
+// 按原始取值(含类型)生成单元格的key，空值单独标记，与文本"<nil>"等取值区分
+
+func pivotKey(values []interface{}) string {
+    var builder strings.Builder
+    builder.WriteString(fmt.Sprintf("%d", len(values)))
+    for _, value := range values {
+        if value == nil {
+            builder.WriteString("\x00n")
+            continue
+        }
+        builder.WriteString(fmt.Sprintf("\x00v%T:%v", value, value))
+    }
+
+    return builder.String()
+}
+
+// 由明细维度值组合生成行头/列头以及对应的key，小计跟在所属分组之后，总计在最后
+
+func pivotHeadersBuild(tuples []pivotTuple, dimNum int, subtotal bool) ([]common.PivotHeader, []string) {
+    var headers []common.PivotHeader
+    var keys []string
+    for index, _ := range tuples {
+        tuple := tuples[index]
+        headers = append(headers, common.PivotHeader{Values: tuple.labels, Level: dimNum})
+        keys = append(keys, pivotKey(tuple.values))
+        if !subtotal {
+            continue
+        }
+        for level := dimNum - 1; level >= 1; level-- {
+            if index == len(tuples) - 1 || pivotKey(tuple.values[:level]) != pivotKey(tuples[index + 1].values[:level]) {
+                headers = append(headers, common.PivotHeader{Values: tuple.labels[:level], Level: level})
+                keys = append(keys, pivotKey(tuple.values[:level]))
+            }
+        }
+    }
+    if dimNum > 0 || len(headers) == 0 {
+        headers = append(headers, common.PivotHeader{Values: []string{}, Level: 0})
+        keys = append(keys, pivotKey(nil))
+    }
+
+    return headers, keys
+}
+
+// 只保留指定维度的字段副本，用于按层级聚合
+
+func pivotLevelFields(fields []common.DatasetTableField, dims []string) []common.DatasetTableField {
+    dimMap := make(map[string]struct{})
+    for _, name := range dims {
+        dimMap[name] = struct{}{}
+    }
+
+    levelFields := make([]common.DatasetTableField, len(fields))
+    copy(levelFields, fields)
+    for index, _ := range levelFields {
+        if levelFields[index].GroupType != common.FieldDimension {
+            continue
+        }
+        if _, ok := dimMap[levelFields[index].Name]; !ok {
+            levelFields[index].Checked = 0
+        }
+    }
+
+    return levelFields
+}
+
+// 透视查询结果中行、列维度层级的列名
+const (
+    pivotRowLevelColumn = "__pivot_row_level"
+    pivotColumnLevelColumn = "__pivot_column_level"
+)
+
+// 各行、列维度层级组合的聚合以union all合并为一次查询，不参与该层级的维度输出空值，以层级列区分
+// 小计、总计同样由数据库聚合，因而avg、count_distinct等不可累加的指标也能得到正确结果
+// 结果按层级、维度升序排列
+
+func (s *SqlDriver) sqlPivotBuild(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery,
+    granMap map[string]string) (*sqlQuery, error) {
+    pivot := query.Pivot
+    allDims := append(append([]string{}, pivot.Rows...), pivot.Columns...)
+    rowLevel := s.dialect.QuoteIdent(pivotRowLevelColumn)
+    columnLevel := s.dialect.QuoteIdent(pivotColumnLevelColumn)
+
+    union := &sqlQuery{}
+    for _, rowNum := range pivotLevels(len(pivot.Rows), pivot.Subtotal) {
+        for _, columnNum := range pivotLevels(len(pivot.Columns), pivot.Subtotal) {
+            dims := append(append([]string{}, pivot.Rows[:rowNum]...), pivot.Columns[:columnNum]...)
+            project, parts, err := s.sqlAggregateSourceBuild(ctx, di, pivotLevelFields(fields, dims), query, granMap, nil)
+            if err != nil {
+                return nil, err
+            }
+
+            dimMap := make(map[string]struct{})
+            for _, name := range dims {
+                dimMap[name] = struct{}{}
+            }
+            var selects []string
+            for _, name := range allDims {
+                if _, ok := dimMap[name]; ok {
+                    selects = append(selects, s.dialect.QuoteIdent(name))
+                } else {
+                    selects = append(selects, fmt.Sprintf("null as %s", s.dialect.QuoteIdent(name)))
+                }
+            }
+            selects = append(selects, parts.quotaSelects()...)
+            selects = append(selects, fmt.Sprintf("%d as %s", rowNum, rowLevel), fmt.Sprintf("%d as %s", columnNum, columnLevel))
+
+            if union.String() != "" {
+                union.Write(" union all ")
+            }
+            union.Write(fmt.Sprintf("select %s from ", strings.Join(selects, ", "))).Write(s.dialect.SubQuery(project.String()), project.Args()...)
+            if len(parts.dims) > 0 {
+                union.Write(fmt.Sprintf(" group by %s", strings.Join(parts.dims, ", ")))
+            }
+        }
+    }
+
+    sorts := []string{s.dialect.OrderBy(rowLevel, common.SortDesc, ""), s.dialect.OrderBy(columnLevel, common.SortDesc, "")}
+    for _, name := range allDims {
+        sorts = append(sorts, s.dialect.OrderBy(s.dialect.QuoteIdent(name), common.SortAsc, ""))
+    }
+    sql := &sqlQuery{}
+    sql.Write("select * from ").Write(s.dialect.SubQuery(union.String()), union.Args()...)
+    sql.Write(fmt.Sprintf(" order by %s", strings.Join(sorts, ", ")))
+
+    return sql, nil
+}
+
+// 透视查询：一次查询取出明细、小计以及总计，再按行头、列头组装单元格
+
+func (s *SqlDriver) pivotGet(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery) (*common.DsResult, error) {
+    pivot := query.Pivot
+    if query.Offset != 0 || query.Limit != 0 || query.Cursor != "" {
+        return nil, errors.New("pivot query not support paging")
+    }
+    if query.TopN != nil || query.Compare != nil || len(query.Sorts) > 0 {
+        return nil, errors.New("pivot query not support top n, compare or sorts, rows and columns are ordered by dimension values")
+    }
+
+    pivotFields, measures, err := pivotFieldsBuild(pivot, fields)
+    if err != nil {
+        return nil, err
+    }
+    base := *query
+    base.Aggregate = true
+    granMap, err := timeGranBuild(pivotFields, &base)
+    if err != nil {
+        return nil, err
+    }
+    defMap := make(map[string]FieldDef)
+    for _, def := range fieldDefListBuild(pivotFields, common.FieldDimension, granMap) {
+        defMap[def.Name] = def
+    }
+    tupleBuild := func(row common.SqlRes, names []string) pivotTuple {
+        tuple := pivotTuple{values: make([]interface{}, 0, len(names)), labels: make([]string, 0, len(names))}
+        for _, name := range names {
+            tuple.values = append(tuple.values, row[name])
+            tuple.labels = append(tuple.labels, dimensionLabel(row[name], defMap[name]))
+        }
+        return tuple
+    }
+
+    sql, err := s.sqlPivotBuild(ctx, di, pivotFields, &base, granMap)
+    if err != nil {
+        return nil, err
+    }
+    sqlRes, err := s.sqlQueryExec(ctx, sql)
+    if err != nil {
+        return nil, err
+    }
+
+    cellMap := make(map[string][]interface{})
+    var rowTuples []pivotTuple
+    var columnTuples []pivotTuple
+    for index, _ := range sqlRes {
+        rowNum, _ := resultNumber(sqlRes[index][pivotRowLevelColumn])
+        columnNum, _ := resultNumber(sqlRes[index][pivotColumnLevelColumn])
+        row := tupleBuild(sqlRes[index], pivot.Rows[:int(rowNum)])
+        column := tupleBuild(sqlRes[index], pivot.Columns[:int(columnNum)])
+        cell := make([]interface{}, 0, len(measures))
+        for _, name := range measures {
+            cell = append(cell, sqlRes[index][name])
+        }
+        cellMap[pivotKey(row.values) + "\x01" + pivotKey(column.values)] = cell
+
+        if int(rowNum) == len(pivot.Rows) && columnNum == 0 {
+            rowTuples = append(rowTuples, row)
+        }
+        if rowNum == 0 && int(columnNum) == len(pivot.Columns) {
+            columnTuples = append(columnTuples, column)
+        }
+    }
+
+    rows, rowKeys := pivotHeadersBuild(rowTuples, len(pivot.Rows), pivot.Subtotal)
+    columns, columnKeys := pivotHeadersBuild(columnTuples, len(pivot.Columns), pivot.Subtotal)
+    result := &common.PivotResult{
+        RowFields: pivot.Rows,
+        ColumnFields: pivot.Columns,
+        Measures: measures,
+        Rows: rows,
+        Columns: columns,
+    }
+    for _, rowKey := range rowKeys {
+        cells := make([][]interface{}, 0, len(columnKeys))
+        for _, columnKey := range columnKeys {
+            cells = append(cells, cellMap[rowKey + "\x01" + columnKey])
+        }
+        result.Cells = append(result.Cells, cells)
+    }
+
+    checked, err := checkedFieldsBuild(pivotFields)
+    if err != nil {
+        return nil, err
+    }
+    dsRes := &common.DsResult{Fields: checked, Pivot: result}
+    dsPageFill(dsRes, query, int64(len(result.Rows)), false)
+
+    return dsRes, nil
+}
@@ -0,0 +1,100 @@
+package db_driver
+
+import (
+    "context"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+)
+
+// 每个字段统计出现次数最多的取值个数
+const profileTopValues = 5
+
+// 统计结果的列名，按字段序号区分
+const profileColumnPrefix = "__profile_"
+
+func profileColumn(index int, stat string) string {
+    return fmt.Sprintf("%s%d_%s", profileColumnPrefix, index, stat)
+}
+
+// 字段统计：一次查询统计所有字段的非空数、去重数、最值以及数值字段的均值，再逐个字段分组查询出现次数最多的取值
+// sql类型数据集的变量使用默认值
+
+func (s *SqlDriver) GetFieldProfiles(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField) (map[string]common.FieldProfile, error) {
+    ctx, cancel := queryContext(ctx, s.datasourceInfo.Config)
+    defer cancel()
+    from, err := s.sqlFromBuild(ctx, di, fields, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    // 支持近似去重的方言使用近似值，代价远低于精确去重
+    approx := s.dialect.ApproxDistinct()
+    distinctFunc := FuncCountDistinct
+    if approx {
+        distinctFunc = FuncApproxDistinct
+    }
+
+    rows := s.dialect.QuoteIdent(profileColumnPrefix + "rows")
+    selects := []string{fmt.Sprintf("%s as %s", s.dialect.Func(FuncCount), rows)}
+    for index, _ := range fields {
+        field := &fields[index]
+        col := s.sqlFieldColumn(field)
+        selects = append(selects,
+            fmt.Sprintf("%s as %s", s.dialect.Func(FuncCount, col), s.dialect.QuoteIdent(profileColumn(index, "count"))),
+            fmt.Sprintf("%s as %s", s.dialect.Func(distinctFunc, col), s.dialect.QuoteIdent(profileColumn(index, "distinct"))),
+        )
+        // 布尔类型在部分数据库(如postgres)上没有min/max
+        if field.DsType != common.DSTypeBit {
+            selects = append(selects,
+                fmt.Sprintf("%s as %s", s.dialect.Func(FuncMin, col), s.dialect.QuoteIdent(profileColumn(index, "min"))),
+                fmt.Sprintf("%s as %s", s.dialect.Func(FuncMax, col), s.dialect.QuoteIdent(profileColumn(index, "max"))),
+            )
+        }
+        if isNumberType(field.DsType) {
+            selects = append(selects, fmt.Sprintf("%s as %s", s.dialect.Func(FuncAvg, col), s.dialect.QuoteIdent(profileColumn(index, "mean"))))
+        }
+    }
+
+    sql := &sqlQuery{}
+    sql.Write(fmt.Sprintf("select %s from ", strings.Join(selects, ", "))).Append(from)
+    sqlRes, err := s.sqlQueryExec(ctx, sql)
+    if err != nil {
+        return nil, err
+    }
+    stats := common.SqlRes{}
+    if len(sqlRes) > 0 {
+        stats = sqlRes[0]
+    }
+
+    rowCount, _ := resultNumber(stats[profileColumnPrefix + "rows"])
+    profiles := make(map[string]common.FieldProfile)
+    for index, _ := range fields {
+        field := &fields[index]
+        count, _ := resultNumber(stats[profileColumn(index, "count")])
+        distinct, _ := resultNumber(stats[profileColumn(index, "distinct")])
+        profile := common.FieldProfile{
+            FieldId: field.FieldId,
+            Name: field.Name,
+            RowCount: int64(rowCount),
+            NullCount: int64(rowCount) - int64(count),
+            DistinctCount: int64(distinct),
+            DistinctApprox: approx,
+            Min: stats[profileColumn(index, "min")],
+            Max: stats[profileColumn(index, "max")],
+        }
+        if mean, ok := resultNumber(stats[profileColumn(index, "mean")]); ok {
+            profile.Mean = mean
+        }
+
+        // 高频取值需按字段分组，每个字段一次分组查询，数据源部分复用上面构建的结果
+        topRes, err := s.sqlQueryExec(ctx, s.fieldValuesSql(from, field, nil, profileTopValues))
+        if err != nil {
+            return nil, err
+        }
+        profile.TopValues = fieldValuesResult(topRes, field)
+        profiles[field.FieldId] = profile
+    }
+
+    return profiles, nil
+}
@@ -0,0 +1,128 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    cmap "github.com/orcaman/concurrent-map"
+    "sort"
+    "sync"
+    "time"
+)
+
+// 执行中的查询，kill由驱动在下发语句时设置，用于通知数据库服务端终止查询
+
+type runningQuery struct {
+    lock        sync.Mutex
+    info        common.RunningQuery
+    cancel      context.CancelFunc
+    kill        func() error
+}
+
+type runningQueryKey struct{}
+
+// QueryRegistry 执行中查询的登记表，查询登记后驱动通过ctx上报当前执行的语句
+
+type QueryRegistry struct {
+    queryMap    cmap.ConcurrentMap      // id---*runningQuery
+}
+
+func NewQueryRegistry() *QueryRegistry {
+    return &QueryRegistry{queryMap: cmap.New()}
+}
+
+// Start 登记查询，info.QueryId为空时生成并回填，StartTime取当前时间
+// 返回的ctx在CancelQuery时取消，查询结束后需调用done注销
+
+func (r *QueryRegistry) Start(ctx context.Context, info *common.RunningQuery) (context.Context, func(), error) {
+    if info.QueryId == "" {
+        info.QueryId = common.GetUUID()
+    }
+    info.StartTime = time.Now()
+
+    ctx, cancel := context.WithCancel(ctx)
+    q := &runningQuery{info: *info, cancel: cancel}
+    if !r.queryMap.SetIfAbsent(info.QueryId, q) {
+        cancel()
+        return nil, nil, errors.New(fmt.Sprintf("query [%s] already running", info.QueryId))
+    }
+
+    done := func() {
+        r.queryMap.RemoveCb(info.QueryId, func(key string, v interface{}, exists bool) bool {
+            return exists && v == q
+        })
+        cancel()
+    }
+
+    return context.WithValue(ctx, runningQueryKey{}, q), done, nil
+}
+
+// List 查看执行中的查询，按开始时间排序
+
+func (r *QueryRegistry) List() []common.RunningQuery {
+    queries := make([]common.RunningQuery, 0, r.queryMap.Count())
+    for _, v := range r.queryMap.Items() {
+        q := v.(*runningQuery)
+        q.lock.Lock()
+        queries = append(queries, q.info)
+        q.lock.Unlock()
+    }
+    sort.SliceStable(queries, func(i, j int) bool {
+        return queries[i].StartTime.Before(queries[j].StartTime)
+    })
+
+    return queries
+}
+
+// Cancel 取消查询的ctx，驱动支持时同时在数据库服务端终止正在执行的语句
+
+func (r *QueryRegistry) Cancel(queryId string) error {
+    v, ok := r.queryMap.Get(queryId)
+    if !ok {
+        return errors.New(fmt.Sprintf("query [%s] not running", queryId))
+    }
+    q := v.(*runningQuery)
+
+    q.cancel()
+
+    q.lock.Lock()
+    kill := q.kill
+    q.lock.Unlock()
+    if kill == nil {
+        return nil
+    }
+
+    return kill()
+}
+
+// 驱动下发语句前上报终止方法，ctx未登记时不做处理，返回登记的查询ID
+
+func runningQueryTrack(ctx context.Context, kill func(queryId string) func() error) string {
+    q, ok := ctx.Value(runningQueryKey{}).(*runningQuery)
+    if !ok {
+        return ""
+    }
+
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    q.kill = nil
+    if kill != nil {
+        q.kill = kill(q.info.QueryId)
+    }
+
+    return q.info.QueryId
+}
+
+// 记录查询的取数语句，计数、表名校验等辅助语句不记录
+
+func runningQuerySql(ctx context.Context, statement string) {
+    q, ok := ctx.Value(runningQueryKey{}).(*runningQuery)
+    if !ok {
+        return
+    }
+
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    q.info.Sql = statement
+}
@@ -0,0 +1,81 @@
+package db_driver
+
+import (
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+)
+
+// 校验排序键，字段需在数据集中定义，返回方向、空值位置统一为小写的副本
+
+func sortCheck(sorts []common.DsSort, fields []common.DatasetTableField) ([]common.DsSort, error) {
+    if len(sorts) == 0 {
+        return nil, nil
+    }
+
+    fieldMap := fieldNameMapBuild(fields)
+    checked := make([]common.DsSort, 0, len(sorts))
+    for index, _ := range sorts {
+        sort := sorts[index]
+        // 检查sort字段是否在数据集中有定义
+        if _, ok := fieldMap[sort.Field]; !ok {
+            return nil, errors.New(fmt.Sprintf("sort name [%s] not define in dataset", sort.Field))
+        }
+
+        sort.Order = strings.ToLower(sort.Order)
+        switch sort.Order {
+        case "":
+            sort.Order = common.SortAsc
+        case common.SortAsc, common.SortDesc:
+        default:
+            return nil, errors.New(fmt.Sprintf("sort field [%s] order [%s] not support", sort.Field, sort.Order))
+        }
+
+        sort.Nulls = strings.ToLower(sort.Nulls)
+        switch sort.Nulls {
+        case "", common.SortNullsFirst, common.SortNullsLast:
+        default:
+            return nil, errors.New(fmt.Sprintf("sort field [%s] nulls [%s] not support", sort.Field, sort.Nulls))
+        }
+
+        checked = append(checked, sort)
+    }
+
+    return checked, nil
+}
+
+// 标准sql的排序写法
+
+func defaultOrderBy(column string, order string, nulls string) string {
+    if nulls == "" {
+        return column + " " + order
+    }
+
+    return fmt.Sprintf("%s %s nulls %s", column, order, nulls)
+}
+
+// 校验并构建order by子句，聚合查询中按投影后的字段名排序
+
+func (s *SqlDriver) sqlSortBuild(sorts []common.DsSort, fields []common.DatasetTableField, aggregate bool) (string, error) {
+    checked, err := sortCheck(sorts, fields)
+    if err != nil || checked == nil {
+        return "", err
+    }
+
+    fieldMap := fieldNameMapBuild(fields)
+    var parts []string
+    for _, sort := range checked {
+        field := fieldMap[sort.Field]
+        if aggregate && field.Checked != 1 {
+            return "", errors.New(fmt.Sprintf("sort field [%s] not checked, cannot sort aggregate result", sort.Field))
+        }
+        column := s.sqlFieldColumn(field)
+        if aggregate {
+            column = s.dialect.QuoteIdent(field.Name)
+        }
+        parts = append(parts, s.dialect.OrderBy(column, sort.Order, sort.Nulls))
+    }
+
+    return strings.Join(parts, ", "), nil
+}
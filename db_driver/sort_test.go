@@ -0,0 +1,60 @@
+package db_driver
+
+import (
+    "github.com/bingLAN/data_driver/common"
+    "testing"
+)
+
+// 排序键编译为order by子句，聚合查询按投影后的字段名排序
+
+func TestSqlSortBuild(t *testing.T) {
+    fields := testFieldsBuild()
+    cases := []struct {
+        name        string
+        dialect     Dialect
+        sorts       []common.DsSort
+        aggregate   bool
+        want        string
+    }{
+        {"empty", SqliteDialect{}, nil, false, ""},
+        {"multi", SqliteDialect{}, []common.DsSort{
+            {Field: "province"},
+            {Field: "rate", Order: common.SortDesc, Nulls: common.SortNullsFirst},
+        }, false, `"province" asc, "rate" desc nulls first`},
+        {"upper", SqliteDialect{}, []common.DsSort{{Field: "rate", Order: "DESC", Nulls: "LAST"}}, false, `"rate" desc nulls last`},
+        {"rename", SqliteDialect{}, []common.DsSort{{Field: "城市"}}, false, `"city" asc`},
+        {"aggregate", SqliteDialect{}, []common.DsSort{{Field: "total", Order: common.SortDesc}}, true, `"total" desc`},
+        {"mysql nulls", MysqlDialect{}, []common.DsSort{{Field: "rate", Nulls: common.SortNullsLast}}, false, "`rate` is null asc, `rate` asc"},
+    }
+    for _, c := range cases {
+        s := &SqlDriver{dialect: c.dialect}
+        orderBy, err := s.sqlSortBuild(c.sorts, fields, c.aggregate)
+        if err != nil {
+            t.Errorf("%s: %v", c.name, err)
+            continue
+        }
+        if orderBy != c.want {
+            t.Errorf("%s: [%s], want [%s]", c.name, orderBy, c.want)
+        }
+    }
+
+    // 字段未定义、方向以及空值位置不支持、聚合查询按未选中字段排序
+    invalid := []struct {
+        sorts       []common.DsSort
+        aggregate   bool
+    }{
+        {[]common.DsSort{{Field: "unknown"}}, false},
+        {[]common.DsSort{{Field: "city"}}, false},
+        {[]common.DsSort{{Field: "rate", Order: "up"}}, false},
+        {[]common.DsSort{{Field: "rate", Order: "asc, (select 1)"}}, false},
+        {[]common.DsSort{{Field: "rate", Nulls: "middle"}}, false},
+        {[]common.DsSort{{Field: "城市"}}, true},
+    }
+    s := &SqlDriver{dialect: SqliteDialect{}}
+    for _, c := range invalid {
+        _, err := s.sqlSortBuild(c.sorts, fields, c.aggregate)
+        if err == nil {
+            t.Errorf("sorts %+v aggregate %v should fail", c.sorts, c.aggregate)
+        }
+    }
+}
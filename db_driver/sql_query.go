@@ -0,0 +1,36 @@
+package db_driver
+
+import (
+    "strings"
+)
+
+// sqlQuery 组装sql文本及其绑定参数
+// 所有值(过滤条件、分页、sql变量)都以?占位，参数顺序与占位符在文本中出现的顺序一致
+
+type sqlQuery struct {
+    text    strings.Builder
+    args    []interface{}
+}
+
+// Write 追加sql片段及该片段中占位符对应的参数
+
+func (q *sqlQuery) Write(sql string, args ...interface{}) *sqlQuery {
+    q.text.WriteString(sql)
+    q.args = append(q.args, args...)
+
+    return q
+}
+
+// Append 追加另一个查询，常用于将子查询嵌入外层查询
+
+func (q *sqlQuery) Append(sub *sqlQuery) *sqlQuery {
+    return q.Write(sub.String(), sub.args...)
+}
+
+func (q *sqlQuery) String() string {
+    return q.text.String()
+}
+
+func (q *sqlQuery) Args() []interface{} {
+    return q.args
+}
@@ -0,0 +1,219 @@
+package db_driver
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "gorm.io/gorm/clause"
+    "regexp"
+    "strings"
+)
+
+// sql中的变量引用${name}，变量名为字母、数字、下划线
+var sqlVariableRegexp = regexp.MustCompile(`^\$\{([^}]*)\}`)
+var sqlVariableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 解析变量定义
+
+func sqlVariablesParse(details string) (map[string]*common.SqlVariable, error) {
+    variableMap := make(map[string]*common.SqlVariable)
+    if details == "" {
+        return variableMap, nil
+    }
+
+    var variables []common.SqlVariable
+    decoder := json.NewDecoder(strings.NewReader(details))
+    decoder.UseNumber()
+    err := decoder.Decode(&variables)
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("sql variable details invalid: %v", err))
+    }
+
+    for index, _ := range variables {
+        variable := &variables[index]
+        if !sqlVariableNameRegexp.MatchString(variable.Name) {
+            return nil, errors.New(fmt.Sprintf("sql variable name [%s] invalid", variable.Name))
+        }
+        if _, ok := variableMap[variable.Name]; ok {
+            return nil, errors.New(fmt.Sprintf("sql variable [%s] duplicate", variable.Name))
+        }
+        variableMap[variable.Name] = variable
+    }
+
+    return variableMap, nil
+}
+
+// 校验变量值并转换为绑定值，未给出时取默认值
+
+func sqlVariableValue(variable *common.SqlVariable, values map[string]interface{}) (interface{}, error) {
+    value, ok := values[variable.Name]
+    if !ok || value == nil {
+        value = variable.Default
+    }
+    if value == nil {
+        if variable.Required {
+            return nil, errors.New(fmt.Sprintf("sql variable [%s] is required", variable.Name))
+        }
+        return nil, nil
+    }
+
+    field := &common.DatasetTableField{Name: variable.Name, DsType: variable.DsType}
+    bind, err := filterValueConvert(value, field)
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("sql variable [%s] value [%v] invalid: %v", variable.Name, value, err))
+    }
+
+    if len(variable.Allowed) > 0 {
+        for _, allowed := range variable.Allowed {
+            allowedBind, err := filterValueConvert(allowed, field)
+            if err == nil && fmt.Sprintf("%v", allowedBind) == fmt.Sprintf("%v", bind) {
+                return bind, nil
+            }
+        }
+        return nil, errors.New(fmt.Sprintf("sql variable [%s] value [%v] not allowed", variable.Name, value))
+    }
+
+    return bind, nil
+}
+
+// 方言的字符串、引号标识符写法，用于跳过其中的内容
+
+type sqlLiteralStyle struct {
+    quotes          string      // 字符串以及引号标识符使用的引号
+    backslash       string      // 引号内反斜杠转义下一个字符的引号
+    escapeString    bool        // 支持E'...'形式的转义字符串(postgres)
+    dollarQuote     bool        // 支持$tag$...$tag$形式的字符串(postgres)
+}
+
+var sqlDollarTagRegexp = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+func sqlIdentChar(c byte) bool {
+    return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// 引号内的字符串、标识符以及注释的结束位置，start处不是引号或注释时返回start
+// 连续两个引号表示引号本身，style.backslash中的引号内反斜杠转义下一个字符
+
+func sqlLiteralEnd(sql string, start int, style sqlLiteralStyle) int {
+    c := sql[start]
+    backslash := strings.IndexByte(style.backslash, c) >= 0
+    if style.escapeString && (c == 'e' || c == 'E') && start + 1 < len(sql) && sql[start+1] == '\'' &&
+        (start == 0 || !sqlIdentChar(sql[start-1])) {
+        c = '\''
+        backslash = true
+        start++
+    }
+
+    switch {
+    case strings.IndexByte(style.quotes, c) >= 0:
+        for i := start + 1; i < len(sql); i++ {
+            if backslash && sql[i] == '\\' {
+                i++
+                continue
+            }
+            if sql[i] == c {
+                if i + 1 < len(sql) && sql[i+1] == c {
+                    i++
+                    continue
+                }
+                return i + 1
+            }
+        }
+        return len(sql)
+    case style.dollarQuote && c == '$' && (start == 0 || !sqlIdentChar(sql[start-1])):
+        tag := sqlDollarTagRegexp.FindString(sql[start:])
+        if tag == "" {
+            return start
+        }
+        if end := strings.Index(sql[start+len(tag):], tag); end >= 0 {
+            return start + len(tag) + end + len(tag)
+        }
+        return len(sql)
+    case strings.HasPrefix(sql[start:], "--"):
+        if end := strings.IndexByte(sql[start:], '\n'); end >= 0 {
+            return start + end + 1
+        }
+        return len(sql)
+    case strings.HasPrefix(sql[start:], "/*"):
+        if end := strings.Index(sql[start+2:], "*/"); end >= 0 {
+            return start + 2 + end + 2
+        }
+        return len(sql)
+    }
+
+    return start
+}
+
+// 原样输出用户sql片段，片段先交给方言处理其中的?
+// 片段中的?(如字符串'why?'、postgres的jsonb操作符)会被gorm当作占位符而错位消耗后续的绑定参数，
+// 这里改为以不带参数的表达式作为绑定参数，由gorm原样输出
+
+func sqlTextWrite(dialect Dialect, query *sqlQuery, text string) error {
+    text, err := dialect.PlaceholderEscape(text)
+    if err != nil {
+        return err
+    }
+
+    for {
+        index := strings.IndexByte(text, '?')
+        if index < 0 {
+            query.Write(text)
+            return nil
+        }
+        query.Write(text[:index]).Write("?", clause.Expr{SQL: "?"})
+        text = text[index+1:]
+    }
+}
+
+// 将sql中的${name}替换为?并按出现顺序给出绑定参数
+// 变量只能出现在值的位置，引号内以及注释中的${name}原样保留，引号写法由方言决定
+
+func sqlVariableBind(dialect Dialect, sql string, details string, values map[string]interface{}) (*sqlQuery, error) {
+    variableMap, err := sqlVariablesParse(details)
+    if err != nil {
+        return nil, err
+    }
+    for name, _ := range values {
+        if _, ok := variableMap[name]; !ok {
+            return nil, errors.New(fmt.Sprintf("sql variable [%s] not define in dataset", name))
+        }
+    }
+
+    query := &sqlQuery{}
+    last := 0
+    for index := 0; index < len(sql); {
+        if end := dialect.LiteralEnd(sql, index); end > index {
+            index = end
+            continue
+        }
+        loc := sqlVariableRegexp.FindStringSubmatchIndex(sql[index:])
+        if loc == nil {
+            index++
+            continue
+        }
+
+        name := sql[index+loc[2]:index+loc[3]]
+        variable, ok := variableMap[name]
+        if !ok {
+            return nil, errors.New(fmt.Sprintf("sql variable [%s] not define in dataset", name))
+        }
+        value, err := sqlVariableValue(variable, values)
+        if err != nil {
+            return nil, err
+        }
+        err = sqlTextWrite(dialect, query, sql[last:index])
+        if err != nil {
+            return nil, err
+        }
+        query.Write("?", value)
+        index += loc[1]
+        last = index
+    }
+    err = sqlTextWrite(dialect, query, sql[last:])
+    if err != nil {
+        return nil, err
+    }
+
+    return query, nil
+}
@@ -0,0 +1,110 @@
+package db_driver
+
+import (
+    "gorm.io/gorm/clause"
+    "reflect"
+    "testing"
+)
+
+// 变量绑定按方言跳过字符串、引号标识符以及注释，片段中的?不占用变量的绑定参数
+
+func TestSqlVariableBind(t *testing.T) {
+    details := `[{"name": "x", "default": "v"}]`
+    literal := clause.Expr{SQL: "?"}
+    cases := []struct {
+        name    string
+        dialect Dialect
+        sql     string
+        want    string
+        args    []interface{}
+    }{
+        {"postgres backslash", PostgresDialect{}, `select '\' as a, ${x} as b`, `select '\' as a, ? as b`, []interface{}{"v"}},
+        {"postgres escape string", PostgresDialect{}, `select E'\' ${x}' as a, ${x} as b`, `select E'\' ${x}' as a, ? as b`, []interface{}{"v"}},
+        {"postgres dollar", PostgresDialect{}, `select $$ it's ${x} $$ as a, ${x} as b`, `select $$ it's ${x} $$ as a, ? as b`, []interface{}{"v"}},
+        {"postgres dollar tag", PostgresDialect{}, `select $t$ $$ ${x} $t$ as a, ${x} as b`, `select $t$ $$ ${x} $t$ as a, ? as b`, []interface{}{"v"}},
+        {"mysql backslash", MysqlDialect{}, `select 'it\'s ${x}' as a, ${x} as b`, `select 'it\'s ${x}' as a, ? as b`, []interface{}{"v"}},
+        {"sqlite doubled quote", SqliteDialect{}, `select 'it''s ${x}' as a, ${x} as b`, `select 'it''s ${x}' as a, ? as b`, []interface{}{"v"}},
+        {"comment", SqliteDialect{}, "select ${x} -- ${x}\n/* ${x} */", "select ? -- ${x}\n/* ${x} */", []interface{}{"v"}},
+        {"literal question mark", SqliteDialect{}, `select 'why?' || ${x}`, `select 'why?' || ?`, []interface{}{literal, "v"}},
+        {"postgres jsonb", PostgresDialect{}, `select data ? 'k', ${x}`, `select data ? 'k', ?`, []interface{}{literal, "v"}},
+        {"clickhouse question mark", ClickhouseDialect{}, `select 'why?\?@' as a, ${x} as b`, `select 'why\x3F\x3F\x40' as a, ? as b`, []interface{}{"v"}},
+        {"clickhouse comment", ClickhouseDialect{}, "-- who?\nselect ${x} /* ? */", "\nselect ?  ", []interface{}{"v"}},
+        {"clickhouse escape", ClickhouseDialect{}, `select 'a\'b?' as a, ${x}`, `select 'a\'b\x3F' as a, ?`, []interface{}{"v"}},
+    }
+    for _, c := range cases {
+        query, err := sqlVariableBind(c.dialect, c.sql, details, nil)
+        if err != nil {
+            t.Errorf("%s: %v", c.name, err)
+            continue
+        }
+        if query.String() != c.want {
+            t.Errorf("%s: sql [%s], want [%s]", c.name, query.String(), c.want)
+        }
+        if !reflect.DeepEqual(query.Args(), c.args) {
+            t.Errorf("%s: args %v, want %v", c.name, query.Args(), c.args)
+        }
+    }
+
+    // clickhouse引号外的?无法与占位符区分
+    _, err := sqlVariableBind(ClickhouseDialect{}, "select x > 1 ? 'a' : 'b', ${x}", details, nil)
+    if err == nil {
+        t.Errorf("clickhouse ? outside quotes should fail")
+    }
+    // 未定义的变量
+    _, err = sqlVariableBind(SqliteDialect{}, "select ${y}", details, nil)
+    if err == nil {
+        t.Errorf("undefined variable should fail")
+    }
+}
+
+// 变量值按定义的类型转换，未给出时取默认值，并校验必填以及允许的取值
+
+func TestSqlVariableValue(t *testing.T) {
+    details := `[
+        {"name": "prov", "dsType": 0, "default": "广东省", "allowed": ["广东省", "北京市"]},
+        {"name": "min_bytes", "dsType": 2, "required": true},
+        {"name": "start", "dsType": 1, "default": "2023-01-01"}
+    ]`
+    sql := "select * from flow where province = ${prov} and ul_bytes >= ${min_bytes} and ts >= ${start}"
+    cases := []struct {
+        values  map[string]interface{}
+        args    []interface{}
+    }{
+        {map[string]interface{}{"min_bytes": 0}, []interface{}{"广东省", int64(0), "2023-01-01 00:00:00"}},
+        {map[string]interface{}{"prov": "北京市", "min_bytes": "200", "start": "2023/05/01"},
+            []interface{}{"北京市", int64(200), "2023-05-01 00:00:00"}},
+    }
+    for _, c := range cases {
+        query, err := sqlVariableBind(SqliteDialect{}, sql, details, c.values)
+        if err != nil {
+            t.Errorf("values %v: %v", c.values, err)
+            continue
+        }
+        if !reflect.DeepEqual(query.Args(), c.args) {
+            t.Errorf("values %v: args %v, want %v", c.values, query.Args(), c.args)
+        }
+    }
+
+    // 不在允许范围、类型不匹配、未定义、必填未给出
+    invalid := []map[string]interface{}{
+        {"min_bytes": 0, "prov": "上海市"},
+        {"min_bytes": 0, "prov": "x' or '1'='1"},
+        {"min_bytes": "abc"},
+        {"min_bytes": 0, "unknown": 1},
+        {},
+    }
+    for _, values := range invalid {
+        _, err := sqlVariableBind(SqliteDialect{}, sql, details, values)
+        if err == nil {
+            t.Errorf("values %v should fail", values)
+        }
+    }
+
+    // 变量名非法、重复
+    for _, details := range []string{`[{"name": "a b"}]`, `[{"name": "x"}, {"name": "x"}]`, `{}`} {
+        _, err := sqlVariableBind(SqliteDialect{}, "select 1", details, nil)
+        if err == nil {
+            t.Errorf("variable details %s should fail", details)
+        }
+    }
+}
@@ -0,0 +1,171 @@
+package db_driver
+
+import (
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+    "time"
+)
+
+// 各分桶粒度的默认标签格式，未分桶的时间字段取完整时间
+var timeGranFormatMap = map[string]string{
+    common.TimeGranMinute: "yyyy-MM-dd HH:mm",
+    common.TimeGranHour: "yyyy-MM-dd HH:00",
+    common.TimeGranDay: "yyyy-MM-dd",
+    common.TimeGranWeek: "yyyy-MM-dd",
+    common.TimeGranMonth: "yyyy-MM",
+    common.TimeGranQuarter: "yyyy-'Q'Q",
+    common.TimeGranYear: "yyyy",
+}
+
+const timeDefaultFormat = "yyyy-MM-dd HH:mm:ss"
+
+// TimeGranCheck 校验字段能否按粒度分桶，粒度为空表示不分桶
+
+func TimeGranCheck(field *common.DatasetTableField, gran string) error {
+    if gran == "" {
+        return nil
+    }
+    if _, ok := timeGranFormatMap[gran]; !ok {
+        return errors.New(fmt.Sprintf("field [%s] granularity [%s] not support", field.Name, gran))
+    }
+    if field.DsType != common.DSTypeTime {
+        return errors.New(fmt.Sprintf("field [%s] is not time field, cannot set granularity", field.Name))
+    }
+
+    return nil
+}
+
+// 确定聚合查询中各时间维度的分桶粒度，查询参数优先于字段配置，返回字段名到粒度的映射
+
+func timeGranBuild(fields []common.DatasetTableField, query *common.DsQuery) (map[string]string, error) {
+    fieldMap := fieldNameMapBuild(fields)
+    for name, _ := range query.Granularity {
+        if _, ok := fieldMap[name]; !ok {
+            return nil, errors.New(fmt.Sprintf("granularity field [%s] not define in dataset", name))
+        }
+    }
+    if !query.Aggregate {
+        if len(query.Granularity) > 0 {
+            return nil, errors.New("granularity only support aggregate query")
+        }
+        return nil, nil
+    }
+
+    granMap := make(map[string]string)
+    for index, _ := range fields {
+        field := &fields[index]
+        gran := field.TimeGranularity
+        if g, ok := query.Granularity[field.Name]; ok {
+            gran = g
+        }
+        if gran == "" {
+            continue
+        }
+        if err := TimeGranCheck(field, gran); err != nil {
+            return nil, err
+        }
+        if field.Checked == 1 && field.GroupType == common.FieldDimension {
+            granMap[field.Name] = gran
+        }
+    }
+
+    return granMap, nil
+}
+
+// 时间字段的标签格式，自定义格式优先，否则按分桶粒度取默认格式
+
+func timeLabelPattern(field *common.DatasetTableField, gran string) string {
+    if field.DateFormatType == common.DateFormatTypeCustom && field.DateFormat != "" {
+        return field.DateFormat
+    }
+    if pattern, ok := timeGranFormatMap[gran]; ok {
+        return pattern
+    }
+
+    return timeDefaultFormat
+}
+
+// 时间值转为标签，数据库以文本返回时先解析，无法解析时原样输出
+
+func timeLabel(value interface{}, pattern string) string {
+    var t time.Time
+    switch v := value.(type) {
+    case time.Time:
+        t = v
+    case string:
+        parsed, ok := parseTimeText(v)
+        if !ok {
+            return v
+        }
+        t = parsed
+    case []byte:
+        parsed, ok := parseTimeText(string(v))
+        if !ok {
+            return string(v)
+        }
+        t = parsed
+    default:
+        return fmt.Sprintf("%v", value)
+    }
+
+    return timeFormat(t, pattern)
+}
+
+// 按yyyy-MM-dd HH:mm:ss风格的格式输出时间
+// 支持y(年) M(月) d(日) H(时) m(分) s(秒) Q(季度) w(ISO周)，单引号内为原样文本
+
+func timeFormat(t time.Time, pattern string) string {
+    var b strings.Builder
+    runes := []rune(pattern)
+    for i := 0; i < len(runes); {
+        c := runes[i]
+        if c == '\'' {
+            end := i + 1
+            for end < len(runes) && runes[end] != '\'' {
+                end++
+            }
+            b.WriteString(string(runes[i + 1:end]))
+            i = end + 1
+            continue
+        }
+
+        n := 1
+        for i + n < len(runes) && runes[i + n] == c {
+            n++
+        }
+        i += n
+
+        var v int
+        switch c {
+        case 'y':
+            if n == 2 {
+                b.WriteString(fmt.Sprintf("%02d", t.Year() % 100))
+            } else {
+                b.WriteString(fmt.Sprintf("%04d", t.Year()))
+            }
+            continue
+        case 'M':
+            v = int(t.Month())
+        case 'd':
+            v = t.Day()
+        case 'H':
+            v = t.Hour()
+        case 'm':
+            v = t.Minute()
+        case 's':
+            v = t.Second()
+        case 'Q':
+            v = (int(t.Month()) - 1) / 3 + 1
+        case 'w':
+            _, v = t.ISOWeek()
+        default:
+            b.WriteString(strings.Repeat(string(c), n))
+            continue
+        }
+        b.WriteString(fmt.Sprintf("%0*d", n, v))
+    }
+
+    return b.String()
+}
@@ -0,0 +1,165 @@
+package db_driver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bingLAN/data_driver/common"
+    "strings"
+    "time"
+)
+
+// 校验排名查询参数，返回排名依据的指标字段以及补全默认值的参数副本
+
+func topNCheck(query *common.DsQuery, fields []common.DatasetTableField) (*common.DatasetTableField, *common.DsTopN, error) {
+    topN := *query.TopN
+    if topN.N <= 0 {
+        return nil, nil, errors.New(fmt.Sprintf("top n [%d] need > 0", topN.N))
+    }
+    if query.Offset != 0 || query.Limit != 0 || query.Cursor != "" {
+        return nil, nil, errors.New("top n query not support paging")
+    }
+    if query.Compare != nil {
+        return nil, nil, errors.New("top n query not support compare")
+    }
+
+    fieldMap := fieldNameMapBuild(fields)
+    field, ok := fieldMap[topN.Field]
+    if !ok {
+        return nil, nil, errors.New(fmt.Sprintf("top n field [%s] not define in dataset", topN.Field))
+    }
+    if field.Checked != 1 || field.GroupType != common.FieldQuota {
+        return nil, nil, errors.New(fmt.Sprintf("top n field [%s] need checked quota field", topN.Field))
+    }
+    hasDim := false
+    for index, _ := range fields {
+        if fields[index].Checked == 1 && fields[index].GroupType == common.FieldDimension {
+            hasDim = true
+        }
+    }
+    if !hasDim {
+        return nil, nil, errors.New("top n query need checked dimension field")
+    }
+
+    topN.Order = strings.ToLower(topN.Order)
+    switch topN.Order {
+    case "":
+        topN.Order = common.SortDesc
+    case common.SortAsc, common.SortDesc:
+    default:
+        return nil, nil, errors.New(fmt.Sprintf("top n order [%s] not support", topN.Order))
+    }
+    if topN.OthersName == "" {
+        topN.OthersName = common.TopNOthersName
+    }
+
+    return field, &topN, nil
+}
+
+// 排名查询：按排名指标排序多取一组判断是否有其余分组，有则在不属于前N组的数据上聚合出Others分组
+
+func (s *SqlDriver) topNGet(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery) (*common.DsResult, error) {
+    field, topN, err := topNCheck(query, fields)
+    if err != nil {
+        return nil, err
+    }
+
+    // 其余排序键作为排名相同时的次序
+    top := *query
+    top.TopN = nil
+    top.Aggregate = true
+    top.Sorts = append([]common.DsSort{{Field: field.Name, Order: topN.Order}}, query.Sorts...)
+    top.Limit = topN.N + 1
+    sqlRes, err := s.sqlExec(ctx, di, fields, &top, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(sqlRes) > topN.N {
+        sqlRes = sqlRes[:topN.N]
+        others, err := s.topNOthersGet(ctx, di, fields, &top, sqlRes, topN.OthersName)
+        if err != nil {
+            return nil, err
+        }
+        sqlRes = append(sqlRes, others)
+    }
+
+    checked, err := checkedFieldsBuild(fields)
+    if err != nil {
+        return nil, err
+    }
+    rowProject(sqlRes, checked)
+    granMap, err := timeGranBuild(fields, &top)
+    if err != nil {
+        return nil, err
+    }
+
+    dsRes := dsResultBuild(sqlRes, checked, granMap)
+    dsPageFill(dsRes, query, int64(len(sqlRes)), false)
+
+    return dsRes, nil
+}
+
+// 在投影结果中排除前N组后聚合各指标，维度值均填为othersName
+
+func (s *SqlDriver) topNOthersGet(ctx context.Context, di *common.DatasetTable, fields []common.DatasetTableField, query *common.DsQuery,
+    topRows []common.SqlRes, othersName string) (common.SqlRes, error) {
+    granMap, err := timeGranBuild(fields, query)
+    if err != nil {
+        return nil, err
+    }
+    project, parts, err := s.sqlAggregateSourceBuild(ctx, di, fields, query, granMap, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    dimFields := make([]common.DatasetTableField, 0, len(parts.dims))
+    for index, _ := range fields {
+        if fields[index].Checked == 1 && fields[index].GroupType == common.FieldDimension {
+            dimFields = append(dimFields, fields[index])
+        }
+    }
+
+    // 前N组的维度值组合，空值用is null匹配
+    conds := &sqlQuery{}
+    for rowIndex, _ := range topRows {
+        if rowIndex > 0 {
+            conds.Write(" or ")
+        }
+        conds.Write("(")
+        for index, _ := range dimFields {
+            if index > 0 {
+                conds.Write(" and ")
+            }
+            col := parts.dims[index]
+            v := topRows[rowIndex][dimFields[index].Name]
+            switch value := v.(type) {
+            case nil:
+                conds.Write(col + " is null")
+            case time.Time:
+                conds.Write(col + " = ?", value.Format(timeTextFormat))
+            default:
+                conds.Write(col + " = ?", value)
+            }
+        }
+        conds.Write(")")
+    }
+
+    sql := &sqlQuery{}
+    sql.Write(fmt.Sprintf("select %s from ", strings.Join(parts.quotaSelects(), ", "))).Write(s.dialect.SubQuery(project.String()), project.Args()...)
+    sql.Write(" where not (").Append(conds).Write(")")
+    result, err := s.sqlQueryExec(ctx, sql)
+    if err != nil {
+        return nil, err
+    }
+
+    others := make(common.SqlRes)
+    if len(result) > 0 {
+        others = result[0]
+    }
+    for index, _ := range dimFields {
+        others[dimFields[index].Name] = othersName
+    }
+
+    return others, nil
+}